@@ -0,0 +1,186 @@
+package simpledb
+
+import (
+	"errors"
+
+	"github.com/kkonat/simpledb/hash"
+)
+
+// reported by the recovery, when a batch has been written without its commit marker
+var errIncompleteBatch = errors.New("batch without commit marker")
+
+type batchOp[T any] struct {
+	key   string
+	value *T // nil for deletes
+}
+
+// a change of the batch, as it's applied
+type batchChange[T any] struct {
+	batchOp[T]
+	keyHash hash.Type
+	s       *shard[T]
+	oldId   ID   // the item with the key, if found
+	found   bool // if there's an item with the key
+	id      ID   // the new item
+	at      int  // offset of the new item in the batch
+}
+
+// Collects puts and deletes, to be applied atomically with Commit:
+// either all of them are persisted, or none, also after a crash, and readers see either none or all of them
+// not safe for concurrent use
+type Batch[T any] struct {
+	db  *SimpleDb[T]
+	ops []batchOp[T]
+}
+
+// Returns a new, empty batch of changes to the db
+func (db *SimpleDb[T]) NewBatch() *Batch[T] {
+	return &Batch[T]{db: db}
+}
+
+// Adds putting the key, value pair to the batch
+func (b *Batch[T]) Put(key string, value *T) {
+	b.ops = append(b.ops, batchOp[T]{key: key, value: value})
+}
+
+// Adds deleting the key to the batch
+func (b *Batch[T]) Delete(key string) {
+	b.ops = append(b.ops, batchOp[T]{key: key})
+}
+
+// Returns the number of changes in the batch
+func (b *Batch[T]) Len() int {
+	return len(b.ops)
+}
+
+// returns the last change of each key, in the order of the changes
+func (b *Batch[T]) lastOps() []batchOp[T] {
+	last := make(map[string]int, len(b.ops))
+	for i, op := range b.ops {
+		last[op.key] = i
+	}
+	ops := make([]batchOp[T], 0, len(last))
+	for i, op := range b.ops {
+		if last[op.key] == i {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Applies the changes in a single write, framed with a batch marker and a commit marker, the batch is emptied afterwards
+// only the last change of each key counts, if a key to be deleted does not exist, no changes are applied
+func (b *Batch[T]) Commit() error {
+	if err := b.db.commit(b.lastOps(), nil); err != nil {
+		return err
+	}
+	b.ops = nil
+	return nil
+}
+
+// applies the changes atomically, the keys read by a transaction are checked for conflicts first, with their shards locked
+func (db *SimpleDb[T]) commit(ops []batchOp[T], tx *Tx[T]) (err error) {
+	if db.cfg.readOnly {
+		return ErrReadOnly
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	changes := make([]batchChange[T], len(ops))
+	keyHashes := make([]hash.Type, 0, len(ops))
+	for i, op := range ops {
+		changes[i] = batchChange[T]{batchOp: op, keyHash: db.keyHash(op.key)}
+		keyHashes = append(keyHashes, changes[i].keyHash)
+	}
+	if tx != nil {
+		for key := range tx.reads {
+			keyHashes = append(keyHashes, db.keyHash(key))
+		}
+	}
+	shards := db.lockShards(keyHashes)
+	defer db.unlockDurable(&err, shards...)
+
+	if tx != nil {
+		if err = tx.validate(); err != nil {
+			return err
+		}
+	}
+	for i := range changes {
+		c := &changes[i]
+		c.s = db.shardOf(c.keyHash)
+		if c.oldId, c.found, err = db.findItem(c.s, c.key, c.keyHash); err != nil {
+			return err
+		}
+		if c.value == nil && !c.found {
+			return &NotFoundError{}
+		}
+	}
+
+	// encode the changes, markers first, as Update and Delete do
+	batchId := db.genNewId()
+	var body []byte
+	var dead int64
+	for i := range changes {
+		c := &changes[i]
+		var marker []byte
+		switch {
+		case c.value == nil:
+			marker = newMarker(blockTombstone, c.oldId, c.keyHash, nil).getBytes()
+		case c.found:
+			c.id = db.genNewId()
+			marker = newSupersedeMarker(c.oldId, c.id, c.keyHash).getBytes()
+		default:
+			c.id = db.genNewId()
+		}
+		body = append(body, marker...)
+		dead += int64(len(marker))
+		if c.value == nil {
+			continue
+		}
+		item, err := db.encodeItem(c.id, c.key, c.keyHash, c.value)
+		if err != nil {
+			return err
+		}
+		c.at = len(body)
+		body = append(body, item...)
+	}
+	begin := newBatchMarker(batchId, len(body)).getBytes()
+	commit := newMarker(blockCommit, batchId, 0, nil).getBytes()
+	buff := append(append(begin, body...), commit...)
+	offset, err := db.appendBlocks(buff, dead+int64(len(begin)+len(commit)))
+	if err != nil {
+		return err
+	}
+
+	// the shards are locked, so readers see the changes only when all of them are applied
+	bodyAt := offset + int64(len(begin))
+	for _, c := range changes {
+		if c.value == nil {
+			db.deleteById(c.s, c.oldId, c.keyHash)
+			db.keys.remove(c.key, c.oldId)
+			continue
+		}
+		c.s.readCache.add(&cacheItem[T]{id: c.id, key: c.key, keyHash: c.keyHash, value: c.value})
+		db.indexItem(c.s, c.id, c.keyHash, bodyAt+int64(c.at))
+		db.keys.put(c.key, c.id)
+		if c.found {
+			db.deleteById(c.s, c.oldId, c.keyHash)
+		}
+	}
+	return db.synchronize()
+}
+
+// checks if the batch started by the marker at the offset has been committed
+// returns false if the batch runs till the end of file without its commit marker, i.e. its write has been interrupted
+func (db *SimpleDb[T]) batchCommitted(begin *block, offset, fileSize int64) (bool, error) {
+	end := offset + int64(begin.Length) + begin.batchLength()
+	commit, err := readBlock(db.file, end)
+	switch {
+	case err == nil && commit.Type == blockCommit && commit.Id == begin.Id:
+		return true, nil
+	case end >= fileSize || (err != nil && isTornTail(db.file, end, fileSize, err)):
+		return false, nil
+	default:
+		return false, &CorruptBlockError{Offset: offset, Id: begin.Id}
+	}
+}
@@ -0,0 +1,163 @@
+package simpledb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	DeleteDbFile("testBatch")
+	db, _ := Open[Person]("testBatch")
+	db.Put("Person1", &testData[0])
+	db.Put("Person2", &testData[1])
+
+	b := db.NewBatch()
+	b.Put("Person1", &testData[2])
+	b.Delete("Person2")
+	b.Put("Person3", &testData[0])
+	b.Put("Person3", &testData[1]) // the last change counts
+	if err := b.Commit(); err != nil || b.Len() != 0 {
+		t.Fatal("failed to commit batch: ", err)
+	}
+	check := func(db *SimpleDb[Person]) {
+		if val, err := db.Get("Person1"); err != nil || *val != testData[2] {
+			t.Error("wrong value of Person1: ", val, err)
+		}
+		if ok, _ := db.Has("Person2"); ok {
+			t.Error("Person2 should be deleted")
+		}
+		if val, err := db.Get("Person3"); err != nil || *val != testData[1] {
+			t.Error("wrong value of Person3: ", val, err)
+		}
+		if db.Len() != 2 {
+			t.Error("wrong length: ", db.Len())
+		}
+	}
+	check(db)
+
+	b.Put("Person4", &testData[0])
+	b.Delete("Person5")
+	var notFound *NotFoundError
+	if err := b.Commit(); !errors.As(err, &notFound) {
+		t.Error("deleting a missing key should fail the batch, got: ", err)
+	}
+	if ok, _ := db.Has("Person4"); ok {
+		t.Error("failed batch should not be applied")
+	}
+	crash(db) // crash, the index is rebuilt from the file
+
+	db, err := Open[Person]("testBatch")
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Destroy()
+	check(db)
+}
+
+func TestTornBatch(t *testing.T) {
+	DeleteDbFile("testTornBatch")
+	db, _ := Open[Person]("testTornBatch")
+	db.Put("Person1", &testData[0])
+	goodSize := db.currentOffset
+	b := db.NewBatch()
+	b.Put("Person1", &testData[1])
+	b.Put("Person2", &testData[2])
+	b.Commit()
+	crash(db)
+
+	// simulate a crash before the commit marker made it to the file
+	os.Truncate(db.filePath, fileSize(db.filePath)-1)
+	db, err := Open[Person]("testTornBatch")
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Destroy()
+	if r := db.Recovery(); r == nil || r.TruncatedAt != goodSize || !errors.Is(r.Reason, errIncompleteBatch) {
+		t.Error("incomplete batch should be discarded: ", r)
+	}
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("incomplete batch should not be applied: ", val, err)
+	}
+	if ok, _ := db.Has("Person2"); ok || db.Len() != 1 {
+		t.Error("incomplete batch should not be applied")
+	}
+}
+
+func TestBatchSnapshot(t *testing.T) {
+	const Fillers = 5000
+	DeleteDbFile("testBatchSnapshot")
+	const Shards = 16
+	db, _ := Open[Person]("testBatchSnapshot", WithShards(Shards))
+	defer db.Destroy()
+	for i := 0; i < Fillers; i++ {
+		db.Put(fmt.Sprint("Filler", i), &testData[0])
+	}
+	// a key moved back and forth by batches, between the first and the last shard, which a snapshot visits the furthest apart
+	keyIn := func(shard int) string {
+		key := "Moving"
+		for i := 0; db.shardIndex(db.keyHash(key)) != shard; i++ {
+			key = fmt.Sprint("Moving", i)
+		}
+		return key
+	}
+	from, to := keyIn(0), keyIn(Shards-1)
+	db.Put(from, &testData[1])
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3000; i++ {
+			b := db.NewBatch()
+			b.Delete(from)
+			b.Put(to, &testData[1])
+			if err := b.Commit(); err != nil {
+				t.Error("failed to commit batch: ", err)
+				return
+			}
+			from, to = to, from
+		}
+	}()
+	moving := func(key string) bool { return strings.HasPrefix(key, "Moving") }
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for running := true; running; {
+				select {
+				case <-done:
+					running = false
+				default:
+				}
+				keys, err := db.Keys()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n := count(keys, moving); n != 1 {
+					t.Errorf("Keys sees a half-applied batch, %d moving keys", n)
+					return
+				}
+				items, _, err := db.Prefix("Moving")
+				if err != nil || len(items) != 1 {
+					t.Errorf("Prefix sees a half-applied batch, %d moving keys, %v", len(items), err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func count(keys []string, fn func(key string) bool) (n int) {
+	for _, key := range keys {
+		if fn(key) {
+			n++
+		}
+	}
+	return n
+}
@@ -3,33 +3,70 @@ package simpledb
 import (
 	"bytes"
 	"encoding/binary"
-	"os"
+	"errors"
+	"io"
 	"unsafe"
 
 	"github.com/kkonat/simpledb/hash"
 )
 
+type blockType uint8
+
+const (
+	blockItem      blockType = iota // key, value pair
+	blockTombstone                  // marks the item with the header Id as deleted
+	blockSupersede                  // marks the item with the header Id as replaced, value holds the new item's Id
+	blockBatch                      // starts a batch with the header Id, value holds the length of the blocks in the batch
+	blockCommit                     // ends the batch with the header Id, the batch is ignored without it
+	blockMaxId                      // written after the file header by compaction, value holds the next id to be generated
+)
+
+const blockVersion = 2 // version of the block layout, stored in each block header
+
+type blockFlags uint8
+
+const (
+	flagCompressed blockFlags = 1 << iota // value is compressed with the block's Compression algorithm
+	flagEncrypted                         // key and value are sealed with AES-GCM
+)
+
 type blockHeader struct {
-	Length  uint32 // uppercase, because must be exportable for binary encoding
-	Id      ID
-	KeyHash hash.Type
-	KeyLen  uint32 // can not be uint16, data is 32-bit word-aligned anyway, sizeof will return untrue no. of bytes
-	DataLen uint32 // can not be uint
+	Length      uint32 // uppercase, because must be exportable for binary encoding
+	Type        blockType
+	Version     uint8
+	Flags       blockFlags
+	Compression Compression // algorithm of a compressed value
+	Id          ID
+	KeyHash     hash.Type
+	KeyLen      uint32 // can not be uint16, data is 32-bit word-aligned anyway, sizeof will return untrue no. of bytes
+	DataLen     uint32 // can not be uint
+	Crc         uint32 // checksum of the header, key and value, must remain the last field
 }
 
-func (b *blockHeader) read(file *os.File) (err error) {
-	return binary.Read(file, binary.LittleEndian, b)
-}
 func blockheadersSize() int {
 	return int(unsafe.Sizeof(blockHeader{}))
 }
 
+// offset of the Crc field in the encoded header
+func crcOffset() int {
+	return blockheadersSize() - 4
+}
+
 func (b *blockHeader) getBytes() (header []byte) {
 	buff := bytes.NewBuffer(header)
 	binary.Write(buff, binary.LittleEndian, b)
 	return buff.Bytes()
 }
 
+func (b *blockHeader) setBytes(headerBytes []byte) {
+	binary.Read(bytes.NewReader(headerBytes), binary.LittleEndian, b)
+}
+
+// checks if the block length is consistent with the key and value lengths
+func (b *blockHeader) lengthOk() bool {
+	return int64(b.Length) == int64(blockheadersSize())+int64(b.KeyLen)+int64(b.DataLen)
+}
+
 type block struct {
 	blockHeader
 	key   string
@@ -37,12 +74,18 @@ type block struct {
 }
 
 func NewBlock(id ID, key string, value []byte) *block {
+	return newBlock(id, key, hash.Get(key), value)
+}
+
+func newBlock(id ID, key string, keyHash hash.Type, value []byte) *block {
 	var header blockHeader
 	headerLen := blockheadersSize()
 	blockLen := headerLen + len(key) + len(value)
 	header = blockHeader{
+		Type:    blockItem,
+		Version: blockVersion,
 		Id:      id,
-		KeyHash: hash.Get(key),
+		KeyHash: keyHash,
 		KeyLen:  uint32(len(key)),
 		DataLen: uint32(len(value)),
 		Length:  uint32(blockLen),
@@ -51,13 +94,131 @@ func NewBlock(id ID, key string, value []byte) *block {
 	return block
 }
 
+// creates a tombstone or supersede marker for the item with the given id
+func newMarker(kind blockType, id ID, keyHash hash.Type, value []byte) *block {
+	header := blockHeader{
+		Type:    kind,
+		Version: blockVersion,
+		Id:      id,
+		KeyHash: keyHash,
+		DataLen: uint32(len(value)),
+		Length:  uint32(blockheadersSize() + len(value)),
+	}
+	return &block{blockHeader: header, value: value}
+}
+
+// creates a marker saying the item oldId has been replaced by the item newId
+func newSupersedeMarker(oldId, newId ID, keyHash hash.Type) *block {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, uint32(newId))
+	return newMarker(blockSupersede, oldId, keyHash, value)
+}
+
+// creates the marker recording the next id to be generated, so that the ids of the items dropped by compaction are not reused
+func newMaxIdMarker(maxId ID) *block {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, uint32(maxId))
+	return newMarker(blockMaxId, 0, 0, value)
+}
+
+// creates the marker starting a batch, followed by blocks of the given length
+func newBatchMarker(batchId ID, length int) *block {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(length))
+	return newMarker(blockBatch, batchId, 0, value)
+}
+
+// returns the length of the blocks in the batch started by the marker
+func (b *block) batchLength() int64 {
+	return int64(binary.LittleEndian.Uint64(b.value))
+}
+
+// returns the next id to be generated, recorded by the max id marker
+func (b *block) maxId() ID {
+	return ID(binary.LittleEndian.Uint32(b.value))
+}
+
+// returns the id of the item which replaced the one marked by the supersede marker
+func (b *block) supersededBy() ID {
+	return ID(binary.LittleEndian.Uint32(b.value))
+}
+
+// encodes the block and stores its checksum in the header
 func (b *block) getBytes() []byte {
 	headerBytes := b.blockHeader.getBytes()
 	blockBytes := append(headerBytes, b.key...)
 	blockBytes = append(blockBytes, b.value...)
+	b.Crc = checksum(blockBytes)
+	binary.LittleEndian.PutUint32(blockBytes[crcOffset():], b.Crc)
 	return blockBytes
 }
 
+// calculates the checksum of an encoded block, skipping the Crc field itself
+func checksum(blockBytes []byte) uint32 {
+	return hash.Checksum(blockBytes[:crcOffset()], blockBytes[crcOffset()+4:])
+}
+
+// checks if the encoded block matches the checksum stored in its header
+func checksumOk(blockBytes []byte) bool {
+	return binary.LittleEndian.Uint32(blockBytes[crcOffset():]) == checksum(blockBytes)
+}
+
+// reads just the header of the block at the given offset
+func readBlockHeader(r io.ReaderAt, offset int64) (header blockHeader, err error) {
+	headerBytes := make([]byte, blockheadersSize())
+	if _, err = r.ReadAt(headerBytes, offset); err != nil {
+		return header, err
+	}
+	header.setBytes(headerBytes)
+	if !header.lengthOk() {
+		return header, &CorruptBlockError{Offset: offset, Id: header.Id}
+	}
+	return header, nil
+}
+
+// reads the header and the key of the block at the given offset, without its value
+func readBlockKey(r io.ReaderAt, offset int64) (header blockHeader, key string, err error) {
+	if header, err = readBlockHeader(r, offset); err != nil {
+		return header, "", err
+	}
+	keyBytes := make([]byte, header.KeyLen)
+	if _, err = r.ReadAt(keyBytes, offset+int64(blockheadersSize())); err != nil {
+		return header, "", err
+	}
+	return header, string(keyBytes), nil
+}
+
+// reads the block at the given offset and verifies its checksum
+func readBlock(r io.ReaderAt, offset int64) (b *block, err error) {
+	var header blockHeader
+
+	headerBytes := make([]byte, blockheadersSize())
+	if n, err := r.ReadAt(headerBytes, offset); err != nil {
+		if n > 0 && errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	header.setBytes(headerBytes)
+	if !header.lengthOk() {
+		return nil, &CorruptBlockError{Offset: offset, Id: header.Id}
+	}
+
+	buff := make([]byte, header.Length)
+	if _, err = r.ReadAt(buff, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !checksumOk(buff) {
+		return nil, &CorruptBlockError{Offset: offset, Id: header.Id}
+	}
+	b = &block{}
+	b.setBytes(buff)
+	return b, nil
+}
+
 func (b *block) setBytes(blockBytes []byte) {
 	buff := bytes.NewBuffer(blockBytes)
 
@@ -27,3 +27,14 @@ func TestBlock(t *testing.T) {
 	}
 	t.Log("End")
 }
+
+func TestBlockChecksum(t *testing.T) {
+	data := NewBlock(1, "KeyKey", []byte("ValueValue")).getBytes()
+	if !checksumOk(data) {
+		t.Error("checksum mismatch on a valid block")
+	}
+	data[len(data)-1] ^= 0x01 // flip a bit in the value
+	if checksumOk(data) {
+		t.Error("corruption not detected")
+	}
+}
@@ -3,6 +3,8 @@ package simpledb
 import (
 	"container/list"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/kkonat/simpledb/hash"
 )
@@ -14,27 +16,48 @@ type cacheItem[T any] struct {
 	value   *T
 }
 
+// counters are updated atomically, so that they may be read without locking the cache
 type stats struct {
 	requests uint64
 	hits     uint64
 }
+
+// max number of items held by the caches sharing it
+type cacheBudget struct {
+	maxSize uint32
+	used    int64 // updated atomically
+}
+
+// safe for concurrent use, Get calls it under the db read lock
 type cache[T any] struct {
+	mtx        sync.Mutex
 	queue      *list.List
 	queueIndx  map[ID]*list.Element
 	statistics stats
-	maxSize    uint32
+	budget     *cacheBudget
 }
 
 func newCache[T any](CacheSize uint32) (c *cache[T]) {
 	c = &cache[T]{}
-	c.init(CacheSize)
+	c.init(&cacheBudget{maxSize: CacheSize})
 	return
 }
 
-func (c *cache[T]) init(CacheSize uint32) {
-	// only create the map and slice, if cache is actually created
-	c.maxSize = CacheSize
-	c.queueIndx = make(map[ID]*list.Element, CacheSize)
+// creates n caches, which together hold up to CacheSize items
+// each one evicts its own items, so the size may be exceeded by a few items, at most n-1
+func newCaches[T any](n int, CacheSize uint32) []*cache[T] {
+	budget := &cacheBudget{maxSize: CacheSize}
+	caches := make([]*cache[T], n)
+	for i := range caches {
+		caches[i] = &cache[T]{}
+		caches[i].init(budget)
+	}
+	return caches
+}
+
+func (c *cache[T]) init(budget *cacheBudget) {
+	c.budget = budget
+	c.queueIndx = make(map[ID]*list.Element)
 	c.queue = list.New()
 	c.statistics = stats{}
 }
@@ -42,11 +65,23 @@ func (c *cache[T]) init(CacheSize uint32) {
 // adds new item to the cache and drops the oldest one
 func (c *cache[T]) add(item *cacheItem[T]) {
 
-	if uint32(c.queue.Len()) == c.maxSize {
+	if c.budget.maxSize == 0 { // caching disabled
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if el, ok := c.queueIndx[item.id]; ok { // concurrent readers may cache the same item
+		el.Value = item
+		c.queue.MoveToBack(el)
+		return
+	}
+	if atomic.LoadInt64(&c.budget.used) >= int64(c.budget.maxSize) && c.queue.Len() > 0 {
 		first := c.queue.Front()
 		firstId := first.Value.(*cacheItem[T]).id
 		delete(c.queueIndx, firstId) // delete reference first
 		c.queue.Remove(first)        // delete actual item
+	} else {
+		atomic.AddInt64(&c.budget.used, 1)
 	}
 	c.queue.PushBack(item)
 	c.queueIndx[item.id] = c.queue.Back()
@@ -54,48 +89,82 @@ func (c *cache[T]) add(item *cacheItem[T]) {
 
 // checks if the item is in the cache and if so, returns its value
 func (c *cache[T]) getIfExists(id ID) (*cacheItem[T], bool) {
-	c.statistics.requests++
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lookup(id)
+}
+
+// returns the item if it's in the cache, and marks it as the one used most recently
+func (c *cache[T]) get(id ID) (*cacheItem[T], bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	item, ok := c.lookup(id)
+	if ok {
+		c.queue.MoveToBack(c.queueIndx[id])
+	}
+	return item, ok
+}
+
+func (c *cache[T]) lookup(id ID) (*cacheItem[T], bool) {
+	atomic.AddUint64(&c.statistics.requests, 1)
 	if item, ok := c.queueIndx[id]; ok {
-		c.statistics.hits++
+		atomic.AddUint64(&c.statistics.hits, 1)
 		return item.Value.(*cacheItem[T]), true
 	}
 	return nil, false
 }
+
 func (c *cache[T]) contains(id ID) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	_, contains := c.queueIndx[id]
 	return contains
 }
 
 // moves an element in the queue to its end to mars it as the one used most recently
 func (c *cache[T]) touch(id ID) {
-
-	if c.queue.Len() <= 1 {
-		return
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if el, ok := c.queueIndx[id]; ok {
+		c.queue.MoveToBack(el)
 	}
-	el := c.queueIndx[id]
-	delete(c.queueIndx, id)
-	c.queue.Remove(el)
-	c.queue.PushBack(el.Value)
-	c.queueIndx[id] = c.queue.Back()
+}
+
+// returns the number of cached items
+func (c *cache[T]) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.queue.Len()
 }
 
 // removes an item with given id from cache
 func (c *cache[T]) remove(id ID) (ok bool) {
-
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	el, ok := c.queueIndx[id] // find el in queue using index)
 	if ok {
 		c.queue.Remove(el)      // delete el in queue
 		delete(c.queueIndx, id) // delete el in index
+		atomic.AddInt64(&c.budget.used, -1)
 	} else {
 		panic(fmt.Sprintf("no el %d in queue", id))
 	}
 	return
 }
 
+func (c *cache[T]) requests() uint64 {
+	return atomic.LoadUint64(&c.statistics.requests)
+}
+
+func (c *cache[T]) hits() uint64 {
+	return atomic.LoadUint64(&c.statistics.hits)
+}
+
 // Gets rudimentary cache stats
 func (c *cache[T]) GetHitRate() float64 {
-	if c.statistics.requests > 0 {
-		return float64(c.statistics.hits) / float64(c.statistics.requests) * 100
+	requests, hits := c.requests(), c.hits()
+	if requests > 0 {
+		return float64(hits) / float64(requests) * 100
 	} else {
 		return 0
 	}
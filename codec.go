@@ -0,0 +1,117 @@
+package simpledb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+
+	"github.com/near/borsh-go"
+)
+
+// identifies the value encoding, it's recorded in the database file header
+// ids below 256 are reserved for the codecs shipped with the package
+type CodecID uint16
+
+const (
+	CodecBorsh CodecID = iota + 1
+	CodecJSON
+	CodecGob
+	CodecRaw
+)
+
+// encodes and decodes values stored in the database
+type Codec[T any] interface {
+	ID() CodecID
+	Marshal(value *T) ([]byte, error)
+	Unmarshal(data []byte, value *T) error
+}
+
+// Binary Object Representation Serializer for Hashing, the default codec
+type BorshCodec[T any] struct{}
+
+func (BorshCodec[T]) ID() CodecID { return CodecBorsh }
+
+func (BorshCodec[T]) Marshal(value *T) ([]byte, error) {
+	return borsh.Serialize(value)
+}
+
+func (BorshCodec[T]) Unmarshal(data []byte, value *T) error {
+	decoded := value // values are encoded as pointers, i.e. with a presence flag
+	if err := borsh.Deserialize(&decoded, data); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return errors.New("nil value")
+	}
+	*value = *decoded
+	return nil
+}
+
+// encoding/json codec
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) ID() CodecID { return CodecJSON }
+
+func (JSONCodec[T]) Marshal(value *T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte, value *T) error {
+	return json.Unmarshal(data, value)
+}
+
+// encoding/gob codec, each value carries its own type definition, which makes it rather verbose
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) ID() CodecID { return CodecGob }
+
+func (GobCodec[T]) Marshal(value *T) ([]byte, error) {
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(value); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (GobCodec[T]) Unmarshal(data []byte, value *T) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// passes []byte values through as they are
+type RawCodec struct{}
+
+func (RawCodec) ID() CodecID { return CodecRaw }
+
+func (RawCodec) Marshal(value *[]byte) ([]byte, error) {
+	return *value, nil
+}
+
+func (RawCodec) Unmarshal(data []byte, value *[]byte) error {
+	*value = append([]byte(nil), data...) // data may be reused by the caller
+	return nil
+}
+
+// Sets the codec for values, BorshCodec by default
+// the codec must match the type of values of the database
+func WithCodec[T any](codec Codec[T]) Option {
+	return func(c *config) error {
+		if codec == nil {
+			return errors.New("nil codec")
+		}
+		c.codec = codec
+		return nil
+	}
+}
+
+// returns the configured codec, checking if it matches the value type
+func codecFor[T any](cfg *config) (Codec[T], error) {
+	if cfg.codec == nil {
+		return BorshCodec[T]{}, nil
+	}
+	codec, ok := cfg.codec.(Codec[T])
+	if !ok {
+		return nil, errors.New("codec does not match the value type")
+	}
+	return codec, nil
+}
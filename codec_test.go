@@ -0,0 +1,87 @@
+package simpledb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testCodec(t *testing.T, name string, opt Option) {
+	DeleteDbFile(name)
+	db, err := Open[Person](name, opt)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	for i := range testData {
+		db.Append(fmt.Sprint("Person", i), &testData[i])
+	}
+	db.Close()
+
+	db, err = Open[Person](name, opt, WithCacheSize(0))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Destroy()
+	for i := range testData {
+		if val, err := db.Get(fmt.Sprint("Person", i)); err != nil || *val != testData[i] {
+			t.Errorf("%s: item %d not decoded: %v %v", name, i, val, err)
+		}
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	testCodec(t, "testCodecBorsh", WithCodec[Person](BorshCodec[Person]{}))
+	testCodec(t, "testCodecJSON", WithCodec[Person](JSONCodec[Person]{}))
+	testCodec(t, "testCodecGob", WithCodec[Person](GobCodec[Person]{}))
+}
+
+func TestRawCodec(t *testing.T) {
+	DeleteDbFile("testRawCodec")
+	db, err := Open[[]byte]("testRawCodec", WithCodec[[]byte](RawCodec{}), WithCacheSize(0))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Destroy()
+	value := []byte("raw value")
+	db.Append("key", &value)
+	db.Flush()
+	if val, err := db.Get("key"); err != nil || !bytes.Equal(*val, value) {
+		t.Error("raw value not stored as is", val, err)
+	}
+	if _, err := Open[Person]("testRawCodec2", WithCodec[[]byte](RawCodec{})); err == nil {
+		t.Error("codec for a different value type should be rejected")
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	DeleteDbFile("testCodecMismatch")
+	db, _ := Open[Person]("testCodecMismatch", WithCodec[Person](JSONCodec[Person]{}))
+	db.Append("Person1", &testData[0])
+	db.Close()
+
+	_, err := Open[Person]("testCodecMismatch")
+	var headerErr *FileHeaderError
+	if !errors.As(err, &headerErr) || headerErr.Field != "codec" {
+		t.Errorf("file written with json should not open with borsh, got %v", err)
+	}
+	db, _ = Open[Person]("testCodecMismatch", WithCodec[Person](JSONCodec[Person]{}))
+	db.Destroy()
+}
+
+type unencodable struct {
+	C chan int
+}
+
+func TestSerializationError(t *testing.T) {
+	DeleteDbFile("testSerializationError")
+	db, _ := Open[unencodable]("testSerializationError", WithCodec[unencodable](JSONCodec[unencodable]{}))
+	defer db.Destroy()
+	if _, err := db.Append("key", &unencodable{C: make(chan int)}); err == nil { // used to panic
+		t.Error("serialization failure should be returned")
+	}
+	var notFound *NotFoundError
+	if _, err := db.Get("key"); !errors.As(err, &notFound) {
+		t.Error("item should not be stored")
+	}
+}
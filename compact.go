@@ -0,0 +1,192 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const compactExt = ".compact"
+
+// Compacts the database file online: live items are copied to a new file while the db stays usable,
+// then the new file replaces the old one. Item ids do not change, so the read cache remains valid.
+func (db *SimpleDb[T]) Compact(ctx context.Context) error {
+	if db.cfg.readOnly {
+		return ErrReadOnly
+	}
+	db.compactMtx.Lock() // one compaction at a time
+	defer db.compactMtx.Unlock()
+
+	if err := db.compact(ctx); err != nil {
+		return &DbInternalError{oper: "compacting", err: err}
+	}
+	return nil
+}
+
+// starts compaction in the background if the dead bytes ratio exceeds the threshold
+// must be called with db.mtx read-locked
+func (db *SimpleDb[T]) maybeCompact() {
+	ratio, minSize := db.cfg.compactRatio, db.cfg.compactMinSize
+	if ratio <= 0 {
+		return
+	}
+	db.appendMtx.Lock()
+	size := db.currentOffset
+	db.appendMtx.Unlock()
+	if size < minSize || float64(atomic.LoadInt64(&db.deadBytes)) < ratio*float64(size) {
+		return
+	}
+	if !db.compactMtx.TryLock() { // already compacting
+		return
+	}
+	go func() {
+		defer db.compactMtx.Unlock()
+		if err := db.compact(context.Background()); err != nil {
+			db.cfg.log.Warnf("simpledb: %s: automatic compaction failed: %v", db.filePath, err)
+		}
+	}()
+}
+
+func (db *SimpleDb[T]) compact(ctx context.Context) (err error) {
+	// take a snapshot of the file end and of the items to be dropped
+	db.mtx.Lock()
+	select {
+	case <-db.closed:
+		db.mtx.Unlock()
+		return errors.New("database closed")
+	default:
+	}
+	if err = db.writer.flush(); err != nil {
+		db.mtx.Unlock()
+		return err
+	}
+	src, end, deadBytes, maxId := db.file, db.currentOffset, db.deadBytes, db.maxId
+	dropped := db.deletedItems()
+	db.mtx.Unlock()
+
+	tmpPath := db.filePath + compactExt
+	dest, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_RDWR|os.O_CREATE|os.O_TRUNC, db.cfg.perm)
+	if err != nil {
+		return err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			dest.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// copy live items up to the snapshot end, readers and writers carry on meanwhile
+	blockOffsets, length, err := copyLiveBlocks(ctx, src, dest, end, maxId, dropped, db.sealer)
+	if err != nil {
+		return err
+	}
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	select {
+	case <-db.closed:
+		return errors.New("database closed")
+	default:
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	// copy whatever has been appended since the snapshot as is, markers included
+	if err = db.writer.flush(); err != nil {
+		return err
+	}
+	tail := db.currentOffset - end
+	if _, err = io.Copy(dest, io.NewSectionReader(src, end, tail)); err != nil {
+		return err
+	}
+	if err = dest.Sync(); err != nil {
+		return err
+	}
+	if err = db.removeHint(); err != nil { // the hint would not match the compacted file
+		return err
+	}
+	if err = os.Rename(tmpPath, db.filePath); err != nil { // atomically replaces the db file
+		return err
+	}
+	swapped = true
+	if err = syncDir(filepath.Dir(db.filePath)); err != nil {
+		db.cfg.log.Warnf("simpledb: %s: syncing db dir: %v", db.filePath, err)
+	}
+
+	// switch to the new file and remap offsets, ids stay the same
+	db.relocate(blockOffsets, end, length-end, dropped)
+	db.file, db.writer.file = dest, dest
+	db.currentOffset = length + tail
+	db.writer.flushed = db.currentOffset
+	atomic.AddInt64(&db.deadBytes, -deadBytes)
+	if db.syncer != nil { // everything written so far is durable in the new file
+		db.syncer.done(db.syncer.written, nil)
+	}
+	src.Close()
+	return nil
+}
+
+// copies the file header and the live items up to the end offset from src to dest
+// the dropped items and markers may have held the highest ids, so maxId is recorded with a marker after the header
+// items sealed with an old key are re-sealed with the current one, if sealer is not nil
+// returns offsets of the items in dest and its length
+func copyLiveBlocks(ctx context.Context, src io.ReaderAt, dest io.Writer, end int64, maxId ID, dropped map[ID]Flag, sealer *sealer) (blockOffsets map[ID]int64, length int64, err error) {
+	header := make([]byte, fileHeaderSize())
+	if _, err = src.ReadAt(header, 0); err != nil {
+		return nil, 0, err
+	}
+	if maxId > 0 {
+		header = append(header, newMaxIdMarker(maxId).getBytes()...)
+	}
+	if _, err = dest.Write(header); err != nil {
+		return nil, 0, err
+	}
+	length = int64(len(header))
+	blockOffsets = make(map[ID]int64)
+
+	for curpos := fileHeaderSize(); curpos < end; {
+		if err = ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		block, err := readBlock(src, curpos)
+		if err != nil {
+			return nil, 0, err // do not propagate corrupt data to the new file
+		}
+		// markers are not copied, as all the items they refer to are either dropped or alive
+		if _, drop := dropped[block.Id]; !drop && block.Type == blockItem {
+			if sealer != nil {
+				if err = sealer.rekey(block, curpos); err != nil {
+					return nil, 0, err
+				}
+			}
+			n, err := dest.Write(block.getBytes())
+			if err != nil {
+				return nil, 0, err
+			}
+			blockOffsets[block.Id] = length
+			length += int64(n)
+		}
+		curpos += int64(block.Length)
+	}
+	return blockOffsets, length, nil
+}
+
+// rolls back a compaction interrupted by a crash, before the db file is opened
+// the temp file replaces the db file with a single rename, so a temp file left behind has never been swapped in,
+// and it may be incomplete, it's removed even if the db file is missing, e.g. deleted since
+func recoverCompaction(filePath string, log log.FieldLogger) error {
+	tmpPath := filePath + compactExt
+	if _, err := os.Stat(tmpPath); err != nil {
+		return nil // no compaction in progress
+	}
+	log.Warnf("simpledb: %s: rolling back an interrupted compaction", filePath)
+	return os.Remove(tmpPath)
+}
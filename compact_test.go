@@ -0,0 +1,170 @@
+package simpledb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	const CacheSize = 10
+	const N = 200
+	DeleteDbFile("testCompact")
+
+	db, _ := Open[benchmarkData]("testCompact", WithCacheSize(CacheSize))
+	reference := make(map[string]string)
+	for n := 0; n < N; n++ {
+		key := fmt.Sprintf("Item%d", n)
+		d := NewBenchmarkData(n)
+		db.Append(key, d)
+		reference[key] = d.Str
+	}
+	for n := 0; n < N; n += 2 { // replace half of the items and delete a few
+		key := fmt.Sprintf("Item%d", n)
+		d := NewBenchmarkData(n)
+		db.Update(key, d)
+		reference[key] = d.Str
+	}
+	for n := 1; n < N; n += 10 {
+		key := fmt.Sprintf("Item%d", n)
+		db.Delete(key)
+		delete(reference, key)
+	}
+	sizeBefore := db.currentOffset
+
+	// writers keep going while the file is being compacted
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := N; n < N+50; n++ {
+			key := fmt.Sprintf("Item%d", n)
+			db.Append(key, &benchmarkData{Value: uint(n), Str: key})
+		}
+		db.Delete("Item3")
+	}()
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal("compaction failed: ", err)
+	}
+	wg.Wait()
+	for n := N; n < N+50; n++ {
+		key := fmt.Sprintf("Item%d", n)
+		reference[key] = key
+	}
+	delete(reference, "Item3")
+
+	if db.currentOffset >= sizeBefore || fileSize(db.filePath) != db.currentOffset {
+		t.Error("file should shrink")
+	}
+	check := func() {
+		if db.ItemsCount != int64(len(reference)) {
+			t.Error("wrong items count: ", db.ItemsCount, " expected: ", len(reference))
+		}
+		for key, str := range reference {
+			if val, err := db.Get(key); err != nil || val.Str != str {
+				t.Error("wrong value for ", key, err)
+			}
+		}
+	}
+	check()
+	db.Close()
+
+	db, _ = Open[benchmarkData]("testCompact", WithCacheSize(CacheSize))
+	check()
+	db.Destroy()
+}
+
+func TestAutoCompact(t *testing.T) {
+	const CacheSize = 10
+	DeleteDbFile("testAutoCompact")
+
+	db, _ := Open[benchmarkData]("testAutoCompact", WithCacheSize(CacheSize), WithAutoCompact(0.5, 0))
+	db.Append("Item", NewBenchmarkData(0))
+	for n := 1; n < 100; n++ {
+		db.Update("Item", NewBenchmarkData(n))
+	}
+	db.compactMtx.Lock() // wait for the background compaction
+	db.compactMtx.Unlock()
+
+	db.mtx.RLock()
+	if float64(db.deadBytes) >= 0.5*float64(db.currentOffset) {
+		t.Error("file should be compacted automatically")
+	}
+	db.mtx.RUnlock()
+	if val, err := db.Get("Item"); err != nil || val.Value != 99 {
+		t.Error("wrong value", err)
+	}
+	db.Destroy()
+}
+
+func TestInterruptedCompaction(t *testing.T) {
+	const CacheSize = 10
+	DeleteDbFile("testInterrupted")
+
+	db, _ := Open[Person]("testInterrupted", WithCacheSize(CacheSize))
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1])
+	db.Close()
+	tmpPath := db.filePath + compactExt
+
+	// crashed while writing the temp file: rolled back
+	os.WriteFile(tmpPath, []byte("incomplete"), 0600)
+	db, err := Open[Person]("testInterrupted", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if _, err := os.Stat(tmpPath); err == nil {
+		t.Error("temp file should be removed")
+	}
+	if db.ItemsCount != 2 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	db.Close()
+
+	// a temp file left behind must not bring back the items of a deleted db
+	data, _ := os.ReadFile(db.filePath)
+	os.WriteFile(tmpPath, data, 0600)
+	DeleteDbFile("testInterrupted")
+	if _, err := os.Stat(tmpPath); err == nil {
+		t.Error("temp file should be removed with the db")
+	}
+	os.WriteFile(tmpPath, data, 0600) // and the db file is gone
+	db, err = Open[Person]("testInterrupted", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if db.ItemsCount != 0 {
+		t.Error("orphan temp file should be discarded: ", db.ItemsCount)
+	}
+	db.Destroy()
+}
+
+func TestCloseSideBySide(t *testing.T) {
+	const CacheSize = 10
+	names := []string{"testSideBySide1", "testSideBySide2"}
+	dbs := make([]*SimpleDb[Person], len(names))
+	for i, name := range names {
+		DeleteDbFile(name)
+		dbs[i], _ = Open[Person](name, WithCacheSize(CacheSize))
+		dbs[i].Append("Person1", &testData[i])
+		dbs[i].Append("Person2", &testData[2])
+		dbs[i].Delete("Person2")
+	}
+	for _, db := range dbs { // both get reorganized on close, in the same dir
+		if err := db.Close(); err != nil {
+			t.Error("close failed", err)
+		}
+	}
+	for i, name := range names {
+		db, _ := Open[Person](name, WithCacheSize(CacheSize))
+		if val, err := db.Get("Person1"); err != nil || *val != testData[i] {
+			t.Error("wrong value in ", name, err)
+		}
+		if db.ItemsCount != 1 {
+			t.Error("wrong items count: ", db.ItemsCount)
+		}
+		db.Destroy()
+	}
+}
@@ -0,0 +1,214 @@
+package simpledb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// stdlib compression algorithm used for values, recorded in each compressed block's header
+type Compression uint8
+
+const (
+	CompressNone Compression = iota
+	CompressFlate
+	CompressGzip
+	CompressZlib
+)
+
+const defaultCompressThreshold = 128 // values shorter than this are not worth compressing
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressFlate:
+		return "flate"
+	case CompressGzip:
+		return "gzip"
+	case CompressZlib:
+		return "zlib"
+	default:
+		return "unknown"
+	}
+}
+
+// Enables compression of values not shorter than threshold bytes, 0 selects the default threshold
+func WithCompression(alg Compression, threshold int) Option {
+	return func(c *config) error {
+		if alg > CompressZlib {
+			return errors.New("unknown compression algorithm")
+		}
+		if threshold < 0 {
+			return errors.New("compression threshold must not be negative")
+		}
+		if threshold == 0 {
+			threshold = defaultCompressThreshold
+		}
+		c.compression = alg
+		c.compressThreshold = threshold
+		return nil
+	}
+}
+
+// compresses the value, prefixed with its raw length
+func compress(alg Compression, value []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	binary.Write(&buff, binary.LittleEndian, uint32(len(value)))
+
+	var w io.WriteCloser
+	switch alg {
+	case CompressFlate:
+		w, _ = flate.NewWriter(&buff, flate.DefaultCompression)
+	case CompressGzip:
+		w = gzip.NewWriter(&buff)
+	case CompressZlib:
+		w = zlib.NewWriter(&buff)
+	default:
+		return nil, errors.New("unknown compression algorithm")
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// restores the value compressed with compress
+func decompress(alg Compression, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rawLen := binary.LittleEndian.Uint32(data)
+	src := bytes.NewReader(data[4:])
+
+	var r io.ReadCloser
+	var err error
+	switch alg {
+	case CompressFlate:
+		r = flate.NewReader(src)
+	case CompressGzip:
+		r, err = gzip.NewReader(src)
+	case CompressZlib:
+		r, err = zlib.NewReader(src)
+	default:
+		return nil, errors.New("unknown compression algorithm")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	value := make([]byte, rawLen)
+	if _, err = io.ReadFull(r, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// compresses the item's value if it's enabled, long enough and actually shrinks
+func (db *SimpleDb[T]) compressBlock(b *block) error {
+	alg := db.cfg.compression
+	if alg == CompressNone || len(b.value) < db.cfg.compressThreshold {
+		return nil
+	}
+	compressed, err := compress(alg, b.value)
+	if err != nil {
+		return err
+	}
+	if len(compressed) >= len(b.value) {
+		return nil
+	}
+	b.value = compressed
+	b.Flags |= flagCompressed
+	b.Compression = alg
+	b.DataLen = uint32(len(compressed))
+	b.Length = uint32(blockheadersSize() + len(b.key) + len(compressed))
+	return nil
+}
+
+// returns the block's value, decompressed if needed
+func (b *block) rawValue() ([]byte, error) {
+	if b.Flags&flagCompressed == 0 {
+		return b.value, nil
+	}
+	return decompress(b.Compression, b.value)
+}
+
+// database statistics
+type Stats struct {
+	Items            int     // live items
+	CompressedItems  int     // live items with compressed values
+	FileSize         int64   // length of the database file
+	DeadBytes        int64   // bytes taken by deleted or replaced items and markers
+	RawBytes         int64   // size of the live values before compression
+	StoredBytes      int64   // size of the live values in the file
+	CompressionRatio float64 // StoredBytes / RawBytes, 1 if nothing has been compressed
+}
+
+// Returns the database statistics, reads the header of every live item
+func (db *SimpleDb[T]) Stats() (stats Stats, err error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	db.appendMtx.Lock()
+	stats.FileSize = db.currentOffset
+	db.appendMtx.Unlock()
+	stats.DeadBytes = atomic.LoadInt64(&db.deadBytes)
+	for _, s := range db.shards {
+		s.mtx.RLock()
+		err = db.shardStats(s, &stats)
+		s.mtx.RUnlock()
+		if err != nil {
+			return stats, err
+		}
+	}
+	stats.CompressionRatio = 1
+	if stats.RawBytes > 0 {
+		stats.CompressionRatio = float64(stats.StoredBytes) / float64(stats.RawBytes)
+	}
+	return stats, nil
+}
+
+// adds up the sizes of the shard's live items
+func (db *SimpleDb[T]) shardStats(s *shard[T], stats *Stats) error {
+	r := db.reader()
+	rawLen := make([]byte, 4)
+	for id, offset := range s.blockOffsets {
+		if _, deleted := s.toBeDeleted[id]; deleted {
+			continue
+		}
+		header, err := readBlockHeader(r, offset)
+		if err != nil {
+			return err
+		}
+		stats.Items++
+		stats.StoredBytes += int64(header.DataLen)
+		if header.Flags&flagCompressed == 0 {
+			stats.RawBytes += int64(header.DataLen)
+			continue
+		}
+		stats.CompressedItems++
+		if header.Flags&flagEncrypted != 0 { // the raw length is sealed with the value
+			b, err := db.readItemBlock(offset)
+			if err != nil {
+				return err
+			}
+			stats.RawBytes += int64(binary.LittleEndian.Uint32(b.value))
+			continue
+		}
+		valueOffset := offset + int64(blockheadersSize()) + int64(header.KeyLen)
+		if _, err = r.ReadAt(rawLen, valueOffset); err != nil {
+			return err
+		}
+		stats.RawBytes += int64(binary.LittleEndian.Uint32(rawLen))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package simpledb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type document struct {
+	Title string
+	Body  string
+}
+
+func testDocument(i int) *document {
+	return &document{Title: fmt.Sprint("doc", i), Body: strings.Repeat(`{"name":"value","n":1},`, 20+i)}
+}
+
+func TestCompression(t *testing.T) {
+	for _, alg := range []Compression{CompressFlate, CompressGzip, CompressZlib} {
+		name := "testCompression" + alg.String()
+		DeleteDbFile(name)
+		db, err := Open[document](name, WithCompression(alg, 0))
+		if err != nil {
+			t.Fatalf("failed to create database: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			db.Append(fmt.Sprint("doc", i), testDocument(i))
+		}
+		db.Delete("doc0") // make Close reorganize the file
+		db.Close()
+
+		db, _ = Open[document](name, WithCacheSize(0))
+		for i := 1; i < 10; i++ {
+			if val, err := db.Get(fmt.Sprint("doc", i)); err != nil || *val != *testDocument(i) {
+				t.Errorf("%v: item %d not decompressed: %v", alg, i, err)
+			}
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Items != 9 || stats.CompressedItems != 9 {
+			t.Errorf("%v: all items should be compressed, got %+v", alg, stats)
+		}
+		if stats.CompressionRatio >= 0.5 {
+			t.Errorf("%v: repetitive values should compress well, ratio %.2f", alg, stats.CompressionRatio)
+		}
+		db.Destroy()
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	DeleteDbFile("testCompressionThreshold")
+	db, _ := Open[document]("testCompressionThreshold", WithCompression(CompressFlate, 1000), WithCacheSize(0))
+	defer db.Destroy()
+	db.Append("short", &document{Title: "short", Body: strings.Repeat("a", 100)})
+	db.Append("long", &document{Title: "long", Body: strings.Repeat("a", 1000)})
+
+	for key, compressed := range map[string]bool{"short": false, "long": true} {
+		s := db.shardOf(db.keyHash(key))
+		block, err := readBlock(db.reader(), s.blockOffsets[s.keyHashItems[db.keyHash(key)][0]])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (block.Flags&flagCompressed != 0) != compressed {
+			t.Errorf("%s: compressed flag should be %v", key, compressed)
+		}
+	}
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := db.Get("long"); err != nil || len(val.Body) != 1000 {
+		t.Error("compressed item should survive compaction", err)
+	}
+	if stats, _ := db.Stats(); stats.CompressedItems != 1 || stats.CompressionRatio >= 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCompressionOff(t *testing.T) {
+	DeleteDbFile("testCompressionOff")
+	db, _ := Open[document]("testCompressionOff")
+	defer db.Destroy()
+	db.Append("doc", testDocument(0))
+	if stats, _ := db.Stats(); stats.CompressedItems != 0 || stats.CompressionRatio != 1 {
+		t.Errorf("values should not be compressed by default, got %+v", stats)
+	}
+	if _, err := Open[document]("testCompressionBad", WithCompression(CompressZlib+1, 0)); err == nil {
+		t.Error("unknown compression algorithm should be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package simpledb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// returns the AES key (16, 24 or 32 bytes long) with the given id
+type KeyFunc func(keyId uint32) ([]byte, error)
+
+const (
+	keyIdSize     = 4
+	nonceSize     = 12
+	sealOverhead  = keyIdSize + nonceSize + 16 // key id, nonce and GCM tag
+	fileEncrypted = uint16(1)                  // file header flag, blocks are encrypted
+)
+
+// Encrypts keys and values of the items with AES-GCM, using a single key
+func WithEncryptionKey(key []byte) Option {
+	if err := checkKey(key); err != nil {
+		return func(c *config) error { return err }
+	}
+	key = append([]byte(nil), key...)
+	return WithKeyProvider(0, func(keyId uint32) ([]byte, error) {
+		if keyId != 0 {
+			return nil, fmt.Errorf("unknown key id %d", keyId)
+		}
+		return key, nil
+	})
+}
+
+// Encrypts keys and values of the items with AES-GCM, new blocks are sealed with the key currentId,
+// blocks sealed with other keys are read with the keys returned by keys and re-sealed on compaction
+func WithKeyProvider(currentId uint32, keys KeyFunc) Option {
+	return func(c *config) error {
+		if keys == nil {
+			return errors.New("nil key provider")
+		}
+		c.keyId = currentId
+		c.keys = keys
+		return nil
+	}
+}
+
+func checkKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return errors.New("encryption key must be 16, 24 or 32 bytes long")
+}
+
+// seals and opens blocks, caching ciphers for the keys in use
+type sealer struct {
+	current uint32
+	keys    KeyFunc
+
+	mtx   sync.Mutex
+	aeads map[uint32]cipher.AEAD
+}
+
+func newSealer(cfg *config) *sealer {
+	if cfg.keys == nil {
+		return nil
+	}
+	return &sealer{current: cfg.keyId, keys: cfg.keys, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+func (s *sealer) aead(keyId uint32) (cipher.AEAD, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if aead, ok := s.aeads[keyId]; ok {
+		return aead, nil
+	}
+	key, err := s.keys(keyId)
+	if err != nil {
+		return nil, &DbInternalError{oper: fmt.Sprintf("getting key %d", keyId), err: err}
+	}
+	if err = checkKey(key); err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[keyId] = aead
+	return aead, nil
+}
+
+// encrypts the item's key and value with the current key, the header is authenticated, but stays readable
+// the sealed data is split between the key and value as usual
+func (s *sealer) seal(b *block) error {
+	plain := append([]byte(b.key), b.value...)
+	b.Flags |= flagEncrypted
+	b.DataLen = uint32(len(plain) + sealOverhead - len(b.key))
+	b.Length = uint32(blockheadersSize() + len(plain) + sealOverhead)
+
+	sealed, err := s.sealData(plain, b.additionalData())
+	if err != nil {
+		return err
+	}
+	b.key, b.value = string(sealed[:b.KeyLen]), sealed[b.KeyLen:]
+	return nil
+}
+
+// decrypts the item's key and value
+func (s *sealer) open(b *block, offset int64) error {
+	sealed := append([]byte(b.key), b.value...)
+	if len(sealed) < sealOverhead {
+		return &CorruptBlockError{Offset: offset, Id: b.Id}
+	}
+	plain, err := s.openData(sealed, b.additionalData())
+	if err != nil {
+		return err
+	}
+	b.Flags &^= flagEncrypted
+	b.key, b.value = string(plain[:b.KeyLen]), plain[b.KeyLen:]
+	b.DataLen = uint32(len(plain)) - b.KeyLen
+	b.Length = uint32(blockheadersSize() + len(plain))
+	return nil
+}
+
+// encrypts the data with the current key, laid out as key id, nonce, ciphertext and tag, additional data is only authenticated
+func (s *sealer) sealData(plain, additional []byte) ([]byte, error) {
+	aead, err := s.aead(s.current)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, keyIdSize+nonceSize, len(plain)+sealOverhead)
+	binary.LittleEndian.PutUint32(sealed, s.current)
+	if _, err = rand.Read(sealed[keyIdSize:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[keyIdSize:], plain, additional), nil
+}
+
+// decrypts data sealed by sealData, which must be at least sealOverhead long
+func (s *sealer) openData(sealed, additional []byte) ([]byte, error) {
+	keyId := binary.LittleEndian.Uint32(sealed)
+	aead, err := s.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	nonce := sealed[keyIdSize : keyIdSize+nonceSize]
+	plain, err := aead.Open(nil, nonce, sealed[keyIdSize+nonceSize:], additional)
+	if err != nil { // the checksum is fine, so it's the key that does not match
+		return nil, &WrongKeyError{KeyId: keyId}
+	}
+	return plain, nil
+}
+
+// re-seals the block with the current key, if it has been sealed with another one
+func (s *sealer) rekey(b *block, offset int64) error {
+	if b.Type != blockItem || b.Flags&flagEncrypted == 0 {
+		return nil
+	}
+	sealed := append([]byte(b.key), b.value...)
+	if len(sealed) >= keyIdSize && binary.LittleEndian.Uint32(sealed) == s.current {
+		return nil
+	}
+	if err := s.open(b, offset); err != nil {
+		return err
+	}
+	return s.seal(b)
+}
+
+// the header of the sealed block, without the checksum, binds the sealed data to the block
+func (b *block) additionalData() []byte {
+	return b.blockHeader.getBytes()[:crcOffset()]
+}
+
+// reads the block at the given offset and decrypts it, if needed
+func (db *SimpleDb[T]) readItemBlock(offset int64) (*block, error) {
+	b, err := readBlock(db.reader(), offset)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.openBlock(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// decrypts the block read from the given offset, if it's encrypted
+func (db *SimpleDb[T]) openBlock(b *block, offset int64) error {
+	if b.Flags&flagEncrypted == 0 {
+		return nil
+	}
+	if db.sealer == nil {
+		return errors.New("block is encrypted, but no key has been given")
+	}
+	return db.sealer.open(b, offset)
+}
+
+// checks if the key(s) match the file, by decrypting the first live item, if there's any
+func (db *SimpleDb[T]) checkKeys() error {
+	if db.sealer == nil {
+		return nil
+	}
+	first := int64(-1)
+	for _, s := range db.shards {
+		for id, offset := range s.blockOffsets {
+			if _, deleted := s.toBeDeleted[id]; !deleted && (first < 0 || offset < first) {
+				first = offset
+			}
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	_, err := db.readItemBlock(first)
+	return err
+}
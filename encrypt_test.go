@@ -0,0 +1,129 @@
+package simpledb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func keyProvider(keys map[uint32][]byte) KeyFunc {
+	return func(keyId uint32) ([]byte, error) {
+		if key, ok := keys[keyId]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("no key %d", keyId)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	DeleteDbFile("testEncryption")
+	db, err := Open[Person]("testEncryption", WithEncryptionKey(testKey1))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Append("SecretPerson", &testData[0])
+	db.Close()
+
+	contents, _ := os.ReadFile(db.filePath)
+	if bytes.Contains(contents, []byte("SecretPerson")) || bytes.Contains(contents, []byte(testData[0].Name)) {
+		t.Error("keys and values should not be stored in plain text")
+	}
+	if hint, _ := os.ReadFile(db.hintPath()); len(hint) == 0 || bytes.Contains(hint, []byte("SecretPerson")) {
+		t.Error("keys should not be stored in plain text in the hint file")
+	}
+
+	db, err = Open[Person]("testEncryption", WithEncryptionKey(testKey1), WithCacheSize(0))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if val, err := db.Get("SecretPerson"); err != nil || *val != testData[0] {
+		t.Error("failed to get encrypted item", err)
+	}
+	if ok, err := db.Has("SecretPerson"); !ok || err != nil {
+		t.Error("failed to find encrypted key", err)
+	}
+	if _, err := hintOf(db); err != nil {
+		t.Error("hint should match the data file: ", err)
+	}
+	if items, _, err := db.Prefix("Secret"); err != nil || len(items) != 1 || items[0].Key != "SecretPerson" {
+		t.Error("keys should be restored from the sealed hint", items, err)
+	}
+	db.Close()
+
+	var wrongKey *WrongKeyError
+	if _, err = Open[Person]("testEncryption", WithEncryptionKey(testKey2)); !errors.As(err, &wrongKey) {
+		t.Errorf("opening with a wrong key should fail with WrongKeyError, got %v", err)
+	}
+	var headerErr *FileHeaderError
+	if _, err = Open[Person]("testEncryption"); !errors.As(err, &headerErr) || headerErr.Field != "encryption" {
+		t.Errorf("opening an encrypted file without a key should fail, got %v", err)
+	}
+	DeleteDbFile("testEncryption")
+}
+
+func TestEncryptionPlainFile(t *testing.T) {
+	DeleteDbFile("testEncryptionPlain")
+	db, _ := Open[Person]("testEncryptionPlain")
+	db.Append("Person1", &testData[0])
+	db.Close()
+
+	var headerErr *FileHeaderError
+	if _, err := Open[Person]("testEncryptionPlain", WithEncryptionKey(testKey1)); !errors.As(err, &headerErr) {
+		t.Errorf("a plain file should not be opened with a key, got %v", err)
+	}
+	if _, err := Open[Person]("testEncryptionPlain", WithEncryptionKey([]byte("short"))); err == nil {
+		t.Error("invalid key length should be rejected")
+	}
+	DeleteDbFile("testEncryptionPlain")
+}
+
+func TestKeyRotation(t *testing.T) {
+	DeleteDbFile("testKeyRotation")
+	db, _ := Open[Person]("testKeyRotation", WithKeyProvider(1, keyProvider(map[uint32][]byte{1: testKey1})))
+	db.Append("Person0", &testData[0])
+	db.Append("Person1", &testData[1])
+	db.Close()
+
+	// rotate: new items are sealed with key 2, the old ones are re-sealed on compaction
+	db, err := Open[Person]("testKeyRotation", WithKeyProvider(2, keyProvider(map[uint32][]byte{1: testKey1, 2: testKey2})))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	db.Append("Person2", &testData[2])
+	if err = db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open[Person]("testKeyRotation", WithKeyProvider(2, keyProvider(map[uint32][]byte{2: testKey2})), WithCacheSize(0))
+	if err != nil {
+		t.Fatalf("the old key should not be needed after compaction: %v", err)
+	}
+	defer db.Destroy()
+	for i := 0; i < 3; i++ {
+		if val, err := db.Get(fmt.Sprint("Person", i)); err != nil || *val != testData[i] {
+			t.Errorf("item %d not readable with the new key: %v", i, err)
+		}
+	}
+}
+
+func TestEncryptionWithCompression(t *testing.T) {
+	DeleteDbFile("testEncryptionCompression")
+	db, _ := Open[document]("testEncryptionCompression", WithEncryptionKey(testKey1), WithCompression(CompressZlib, 0), WithCacheSize(0))
+	defer db.Destroy()
+	db.Append("doc", testDocument(10))
+	if val, err := db.Get("doc"); err != nil || *val != *testDocument(10) {
+		t.Error("failed to get compressed, encrypted item", err)
+	}
+	if stats, err := db.Stats(); err != nil || stats.CompressedItems != 1 || stats.CompressionRatio >= 1 {
+		t.Errorf("unexpected stats %+v %v", stats, err)
+	}
+}
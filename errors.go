@@ -1,6 +1,25 @@
 package simpledb
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// returned on attempts to modify a database opened in read-only mode, or in a read-only transaction
+var ErrReadOnly = errors.New("database is read-only")
+
+// returned when a transaction can not be committed, because a key it has read has been changed since it started,
+// and by CompareAndSwap, when the key's version is not the expected one
+var ErrConflict = errors.New("transaction conflict")
+
+// returned by Insert when there's already an item with the given key
+var ErrKeyExists = errors.New("key already exists")
+
+// returned by Open in read-only mode for a file with the legacy layout, which is upgraded only by a read-write Open
+var ErrLegacyFile = errors.New("legacy database file, open it read-write once to upgrade it")
+
+// returned by Open when the database is held by another process, or by another db of this process, unless both are read-only
+var ErrLocked = errors.New("database is locked")
 
 // TODO add other custom errors, rather than strings, although now it is not really important
 type NotFoundError struct {
@@ -26,5 +45,44 @@ type DbInternalError struct {
 }
 
 func (r *DbInternalError) Error() string {
-	return fmt.Sprintf("internal error: %s :%W", r.oper, r.err)
+	return fmt.Sprintf("internal error: %s :%v", r.oper, r.err)
+}
+
+func (r *DbInternalError) Unwrap() error {
+	return r.err
+}
+
+// returned when a block read from the file fails the checksum test
+type CorruptBlockError struct {
+	Offset int64 // offset of the block in the database file
+	Id     ID    // id of the block, as read from its (possibly damaged) header
+}
+
+func (r *CorruptBlockError) Error() string {
+	return fmt.Sprintf("corrupt block %d at offset %d", r.Id, r.Offset)
+}
+
+// returned when the database file header does not match the db parameters
+type FileHeaderError struct {
+	Field    string // name of the mismatching header field
+	Found    uint32 // value found in the file
+	Expected uint32 // value expected by the db
+}
+
+func (r *FileHeaderError) Error() string {
+	return fmt.Sprintf("incompatible database file: %s is %d, expected %d", r.Field, r.Found, r.Expected)
+}
+
+// returned when an encrypted block can not be opened with the key it has been sealed with
+type WrongKeyError struct {
+	KeyId uint32 // id of the key the block has been sealed with
+}
+
+func (r *WrongKeyError) Error() string {
+	return fmt.Sprintf("wrong encryption key %d", r.KeyId)
+}
+
+func isCorrupt(err error) bool {
+	var corrupt *CorruptBlockError
+	return errors.As(err, &corrupt)
 }
@@ -0,0 +1,107 @@
+package simpledb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/kkonat/simpledb/hash"
+)
+
+const (
+	fileMagic     = uint32(0x42445353) // "SSDB" in little endian
+	formatVersion = uint16(1)          // version of the file layout
+)
+
+// fixed size header at the beginning of the database file, followed by the data blocks
+type fileHeader struct {
+	Magic   uint32
+	Version uint16         // file layout version
+	HashAlg hash.Algorithm // hash function used for key hashes
+	Codec   CodecID        // value encoding
+	Flags   uint16         // creation flags
+	Crc     uint32         // checksum of the header, must remain the last field
+}
+
+func fileHeaderSize() int64 {
+	return int64(unsafe.Sizeof(fileHeader{}))
+}
+
+// creates the header for a new database file with the db parameters
+func newFileHeader(cfg *config) *fileHeader {
+	return &fileHeader{
+		Magic:   fileMagic,
+		Version: formatVersion,
+		HashAlg: cfg.hashAlg,
+		Codec:   cfg.codecId,
+		Flags:   cfg.fileFlags(),
+	}
+}
+
+func (h *fileHeader) getBytes() []byte {
+	buff := new(bytes.Buffer)
+	binary.Write(buff, binary.LittleEndian, h)
+	headerBytes := buff.Bytes()
+	h.Crc = hash.Checksum(headerBytes[:fileHeaderSize()-4])
+	binary.LittleEndian.PutUint32(headerBytes[fileHeaderSize()-4:], h.Crc)
+	return headerBytes
+}
+
+// reads the header from the beginning of the file
+func (h *fileHeader) read(file *os.File) error {
+	headerBytes := make([]byte, fileHeaderSize())
+	if _, err := file.ReadAt(headerBytes, 0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	binary.Read(bytes.NewReader(headerBytes), binary.LittleEndian, h)
+	if h.Magic != fileMagic {
+		return &FileHeaderError{Field: "magic number", Found: uint32(h.Magic), Expected: fileMagic}
+	}
+	if h.Crc != hash.Checksum(headerBytes[:fileHeaderSize()-4]) {
+		return &CorruptBlockError{Offset: 0}
+	}
+	return nil
+}
+
+// checks if the file can be handled with the current db parameters
+func (h *fileHeader) validate(expected *fileHeader) error {
+	switch {
+	case h.Version != expected.Version:
+		return &FileHeaderError{Field: "format version", Found: uint32(h.Version), Expected: uint32(expected.Version)}
+	case h.HashAlg != expected.HashAlg:
+		return &FileHeaderError{Field: "hash algorithm", Found: uint32(h.HashAlg), Expected: uint32(expected.HashAlg)}
+	case h.Codec != expected.Codec:
+		return &FileHeaderError{Field: "codec", Found: uint32(h.Codec), Expected: uint32(expected.Codec)}
+	case h.Flags&fileEncrypted != expected.Flags&fileEncrypted:
+		return &FileHeaderError{Field: "encryption", Found: uint32(h.Flags & fileEncrypted), Expected: uint32(expected.Flags & fileEncrypted)}
+	}
+	return nil
+}
+
+// validates the header of an existing database file, or writes it if the file is new
+func (db *SimpleDb[T]) initFileHeader() error {
+	stat, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < fileHeaderSize() { // a new file, or the header never made it to disk
+		if db.cfg.readOnly {
+			return io.ErrUnexpectedEOF
+		}
+		if err = db.file.Truncate(0); err != nil {
+			return err
+		}
+		_, err = db.file.Write(newFileHeader(&db.cfg).getBytes())
+		return err
+	}
+	var header fileHeader
+	if err = header.read(db.file); err != nil {
+		return err
+	}
+	return header.validate(newFileHeader(&db.cfg))
+}
@@ -9,19 +9,82 @@ var crc32table *crc32.Table
 type Type uint32 // no need for larger hashes for now
 var hashFunc func(data []byte) Type
 
+// identifies the hash function, so that it can be recorded in the database file
+type Algorithm uint16
+
+const (
+	Custom    Algorithm = iota // a function set with SetFunc
+	CRC32                      // CRC32 (Castagnoli)
+	Superfast                  // Paul Hsieh's superfasthash
+)
+
+var algorithm Algorithm
+
 func init() {
 	crc32table = crc32.MakeTable(0x82f63b78)
-	hashFunc = calcCrc32
-	//hashFunc = calcSuperfasthash // TODO: fails test, debug why
+	SetAlgorithm(CRC32)
 }
 
+// Sets a custom hash function
 func SetFunc(f func(data []byte) Type) {
 	hashFunc = f
+	algorithm = Custom
+}
+
+// Sets one of the built-in hash functions
+func SetAlgorithm(a Algorithm) {
+	switch a {
+	case CRC32:
+		hashFunc = calcCrc32
+	case Superfast:
+		hashFunc = calcSuperfasthash
+	default:
+		panic("unknown hash algorithm")
+	}
+	algorithm = a
+}
+
+// Calculates the hash of the data with the algorithm, Custom uses the function set with SetFunc
+func (a Algorithm) Get(data string) Type {
+	switch a {
+	case CRC32:
+		return calcCrc32([]byte(data))
+	case Superfast:
+		return calcSuperfasthash([]byte(data))
+	default:
+		return hashFunc([]byte(data))
+	}
 }
+
+// Returns the hash function currently in use
+func CurrentAlgorithm() Algorithm {
+	return algorithm
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case CRC32:
+		return "crc32"
+	case Superfast:
+		return "superfasthash"
+	default:
+		return "custom"
+	}
+}
+
+// Calculates the hash of the data with the hash function currently in use
 func Get(data string) Type {
 	return hashFunc([]byte(data))
 }
 
+// Calculates CRC32 (Castagnoli) checksum of the data split into parts
+func Checksum(parts ...[]byte) (crc uint32) {
+	for _, p := range parts {
+		crc = crc32.Update(crc, crc32table, p)
+	}
+	return
+}
+
 func calcCrc32(data []byte) Type {
 	return Type(crc32.Checksum(data, crc32table))
 }
@@ -51,7 +51,7 @@ func calcSuperfasthash(data []byte) Type {
 		hash = hash + get16bits(data, index)
 		tmp := (get16bits(data, index+2) << 11) ^ hash
 		hash = (hash << 16) ^ tmp
-		index += 2
+		index += 4
 		hash += hash >> 11
 	}
 
@@ -0,0 +1,295 @@
+package simpledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kkonat/simpledb/hash"
+)
+
+const (
+	hintExt     = ".hint"
+	hintMagic   = uint32(0x544e4853) // "SHNT" in little endian
+	hintVersion = uint16(5)          // 5: keys of the items are saved for the ordered index
+)
+
+var errHintMismatch = errors.New("hint file does not match the database file")
+
+// fixed size part of the hint file, followed by a section per shard: its counts, the offsets map, the key hash map and the deleted items list,
+// and by the keys section: its length and the id and key of each live item, sealed if the db is encrypted
+type hintHeader struct {
+	Magic           uint32
+	Version         uint16
+	_               uint16
+	DataLength      int64  // length of the data file when the hint was written
+	LastBlockOffset int64  // offset of the last block in the data file, 0 if there are no blocks
+	LastBlockCrc    uint32 // checksum of the last block, to tell if the data file is the one the hint was written for
+	DeadBytes       int64
+	MaxId           ID
+	ItemsCount      uint32
+	Shards          uint32 // number of shards, the hint does not match a db with a different number of shards
+}
+
+// precedes the entries of a shard in the hint file
+type hintShard struct {
+	Offsets   uint32 // number of entries in the offsets map
+	KeyHashes uint32 // number of entries in the key hash map
+	Deleted   uint32 // number of items marked for deletion
+}
+
+func (db *SimpleDb[T]) hintPath() string {
+	return db.filePath + hintExt
+}
+
+// writes the in-memory index to the hint file, atomically replacing the old one
+func (db *SimpleDb[T]) writeHint() (err error) {
+	header := hintHeader{
+		Magic:      hintMagic,
+		Version:    hintVersion,
+		DataLength: db.currentOffset,
+		DeadBytes:  db.deadBytes,
+		MaxId:      db.maxId,
+		ItemsCount: uint32(db.ItemsCount),
+		Shards:     uint32(len(db.shards)),
+	}
+	file, err := os.Open(db.filePath) // the db file may be closed already
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if header.LastBlockOffset, header.LastBlockCrc, err = db.lastBlock(file); err != nil {
+		return err
+	}
+
+	buff := new(bytes.Buffer)
+	w := bufio.NewWriter(buff)
+	binary.Write(w, binary.LittleEndian, &header)
+	for _, s := range db.shards {
+		binary.Write(w, binary.LittleEndian, &hintShard{
+			Offsets:   uint32(len(s.blockOffsets)),
+			KeyHashes: uint32(len(s.keyHashItems)),
+			Deleted:   uint32(len(s.toBeDeleted)),
+		})
+		for id, offset := range s.blockOffsets {
+			binary.Write(w, binary.LittleEndian, id)
+			binary.Write(w, binary.LittleEndian, offset)
+		}
+		for keyHash, ids := range s.keyHashItems {
+			binary.Write(w, binary.LittleEndian, keyHash)
+			binary.Write(w, binary.LittleEndian, uint32(len(ids)))
+			binary.Write(w, binary.LittleEndian, ids)
+		}
+		for id := range s.toBeDeleted {
+			binary.Write(w, binary.LittleEndian, id)
+		}
+	}
+	keys, err := db.hintKeys()
+	if err != nil {
+		return err
+	}
+	binary.Write(w, binary.LittleEndian, uint32(len(keys)))
+	w.Write(keys)
+	w.Flush()
+	binary.Write(buff, binary.LittleEndian, hash.Checksum(buff.Bytes()))
+
+	return writeFileAtomic(db.hintPath(), buff.Bytes(), db.cfg.perm)
+}
+
+// encodes the keys section of the hint file, the keys are sealed in encrypted dbs, as they are in the data file
+func (db *SimpleDb[T]) hintKeys() ([]byte, error) {
+	buff := new(bytes.Buffer)
+	db.keys.each(func(key string, id ID) {
+		binary.Write(buff, binary.LittleEndian, id)
+		binary.Write(buff, binary.LittleEndian, uint32(len(key)))
+		buff.WriteString(key)
+	})
+	if db.sealer == nil {
+		return buff.Bytes(), nil
+	}
+	return db.sealer.sealData(buff.Bytes(), nil)
+}
+
+// finds the last block in the data file, walking the offsets of the indexed items and the file tail
+func (db *SimpleDb[T]) lastBlock(file io.ReaderAt) (offset int64, crc uint32, err error) {
+	for _, s := range db.shards { // the last block is at or after the last indexed item
+		for _, o := range s.blockOffsets {
+			if o > offset {
+				offset = o
+			}
+		}
+	}
+	if offset == 0 {
+		offset = fileHeaderSize()
+	}
+	last := int64(0)
+	for offset < db.currentOffset { // skip to the last block, e.g. past trailing markers
+		header, err := readBlockHeader(file, offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		last, crc = offset, header.Crc
+		offset += int64(header.Length)
+	}
+	return last, crc, nil
+}
+
+// loads the in-memory index from the hint file, if it matches the data file, and the keys of the items into keys
+func (db *SimpleDb[T]) loadHint(keys map[ID]string) (dataLength int64, err error) {
+	data, err := os.ReadFile(db.hintPath())
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < binary.Size(hintHeader{})+4 ||
+		binary.LittleEndian.Uint32(data[len(data)-4:]) != hash.Checksum(data[:len(data)-4]) {
+		return 0, errHintMismatch
+	}
+	r := bytes.NewReader(data[:len(data)-4])
+
+	var header hintHeader
+	binary.Read(r, binary.LittleEndian, &header)
+	if header.Magic != hintMagic || header.Version != hintVersion || header.Shards != uint32(len(db.shards)) {
+		return 0, errHintMismatch
+	}
+	if err = db.checkHint(&header); err != nil {
+		return 0, err
+	}
+
+	shards := newShards[T](len(db.shards), 0) // only the maps are used
+	for _, s := range shards {
+		if err = readHintShard(r, s); err != nil {
+			return 0, errHintMismatch
+		}
+	}
+	if err = db.readHintKeys(r, keys); err != nil {
+		return 0, errHintMismatch
+	}
+
+	for i, s := range db.shards {
+		s.blockOffsets, s.keyHashItems, s.toBeDeleted = shards[i].blockOffsets, shards[i].keyHashItems, shards[i].toBeDeleted
+	}
+	db.maxId = header.MaxId
+	db.deadBytes = header.DeadBytes
+	db.ItemsCount = int64(header.ItemsCount)
+	return header.DataLength, nil
+}
+
+// reads a shard's section of the hint file
+func readHintShard[T any](r *bytes.Reader, s *shard[T]) (err error) {
+	var counts hintShard
+	if err = binary.Read(r, binary.LittleEndian, &counts); err != nil {
+		return err
+	}
+	for i := uint32(0); i < counts.Offsets; i++ {
+		var id ID
+		var offset int64
+		binary.Read(r, binary.LittleEndian, &id)
+		if err = binary.Read(r, binary.LittleEndian, &offset); err != nil {
+			return err
+		}
+		s.blockOffsets[id] = offset
+	}
+	for i := uint32(0); i < counts.KeyHashes; i++ {
+		var keyHash hash.Type
+		var n uint32
+		binary.Read(r, binary.LittleEndian, &keyHash)
+		if err = binary.Read(r, binary.LittleEndian, &n); err != nil || int64(n)*4 > int64(r.Len()) {
+			return errHintMismatch
+		}
+		ids := make([]ID, n)
+		binary.Read(r, binary.LittleEndian, ids)
+		s.keyHashItems[keyHash] = ids
+	}
+	for i := uint32(0); i < counts.Deleted; i++ {
+		var id ID
+		if err = binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return err
+		}
+		s.toBeDeleted[id] = Flag{}
+	}
+	return nil
+}
+
+// reads the keys section of the hint file
+func (db *SimpleDb[T]) readHintKeys(r *bytes.Reader, keys map[ID]string) (err error) {
+	var length uint32
+	if err = binary.Read(r, binary.LittleEndian, &length); err != nil || int64(length) > int64(r.Len()) {
+		return errHintMismatch
+	}
+	data := make([]byte, length)
+	r.Read(data)
+	if db.sealer != nil {
+		if len(data) < sealOverhead {
+			return errHintMismatch
+		}
+		if data, err = db.sealer.openData(data, nil); err != nil {
+			return err
+		}
+	}
+	for kr := bytes.NewReader(data); kr.Len() > 0; {
+		var id ID
+		var n uint32
+		binary.Read(kr, binary.LittleEndian, &id)
+		if err = binary.Read(kr, binary.LittleEndian, &n); err != nil || int64(n) > int64(kr.Len()) {
+			return errHintMismatch
+		}
+		key := make([]byte, n)
+		kr.Read(key)
+		keys[id] = string(key)
+	}
+	return nil
+}
+
+// checks if the data file is the one the hint was written for, possibly with some blocks appended since
+func (db *SimpleDb[T]) checkHint(header *hintHeader) error {
+	stat, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() < header.DataLength || header.DataLength < fileHeaderSize() {
+		return errHintMismatch
+	}
+	if header.LastBlockOffset == 0 { // no blocks
+		return nil
+	}
+	last, err := readBlockHeader(db.file, header.LastBlockOffset)
+	if err != nil || last.Crc != header.LastBlockCrc ||
+		header.LastBlockOffset+int64(last.Length) != header.DataLength {
+		return errHintMismatch
+	}
+	return nil
+}
+
+func (db *SimpleDb[T]) removeHint() error {
+	if err := os.Remove(db.hintPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writes data to a temp file and renames it to the destination path, so that the file is replaced atomically
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, bytes.NewReader(data)); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,207 @@
+package simpledb
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// size of the reads made by iterators, blocks are parsed from the chunk read, rather than read one by one
+const iteratorChunkSize = 64 * 1024
+
+// an item to be visited by an iterator
+type iteratorItem[T any] struct {
+	id     ID
+	shard  *shard[T]
+	offset int64 // -1 if the item has been dropped from the file
+}
+
+// a part of the database file read into memory
+type chunk struct {
+	offset int64
+	data   []byte
+}
+
+func (c *chunk) ReadAt(p []byte, off int64) (int, error) {
+	if off < c.offset || off >= c.offset+int64(len(c.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, c.data[off-c.offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Walks the items live at the time it has been created, in file order, reading the file in large chunks
+// it holds no locks between the calls to Next, so the db may be modified meanwhile,
+// the values are the ones at the time of the snapshot, but items dropped by a compaction in the meantime are skipped
+type Iterator[T any] struct {
+	db         *SimpleDb[T]
+	items      []iteratorItem[T]
+	next       int
+	generation uint64 // of the db, when the offsets have been taken
+	chunk      chunk
+	keysOnly   bool // values are not decoded
+
+	key   string
+	value *T
+	err   error
+}
+
+// Returns an iterator over the items in the db, in file order
+func (db *SimpleDb[T]) Iterator() *Iterator[T] {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.newIterator()
+}
+
+// snapshots the live items, with all the shards read-locked, so a batch is in the snapshot either whole or not at all
+// must be called with db.mtx locked or read-locked
+func (db *SimpleDb[T]) newIterator() *Iterator[T] {
+	it := &Iterator[T]{db: db, generation: db.generation}
+	db.rLockShards()
+	for _, s := range db.shards {
+		for id, offset := range s.blockOffsets {
+			if _, deleted := s.toBeDeleted[id]; !deleted {
+				it.items = append(it.items, iteratorItem[T]{id: id, shard: s, offset: offset})
+			}
+		}
+	}
+	db.rUnlockShards()
+	sort.Slice(it.items, func(i, j int) bool { return it.items[i].offset < it.items[j].offset })
+	return it
+}
+
+// Advances the iterator to the next item, returns false when there are no more items, or on error
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.next < len(it.items) {
+		item := &it.items[it.next]
+		it.next++
+		ok, err := it.read(item)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the key of the current item
+func (it *Iterator[T]) Key() string {
+	return it.key
+}
+
+// Returns the value of the current item
+func (it *Iterator[T]) Value() *T {
+	return it.value
+}
+
+// Returns the error which stopped the iteration, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// reads the item from the current chunk, reading the next chunk from the file if needed
+// returns false if the item has been dropped from the file
+func (it *Iterator[T]) read(item *iteratorItem[T]) (bool, error) {
+	b, err := readBlock(&it.chunk, item.offset)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		if ok, err := it.fill(item); !ok || err != nil {
+			return false, err
+		}
+		b, err = readBlock(&it.chunk, item.offset)
+	}
+	if err != nil {
+		return false, err
+	}
+	if b.Type != blockItem || b.Id != item.id {
+		return false, &CorruptBlockError{Offset: item.offset, Id: item.id}
+	}
+	if err = it.db.openBlock(b, item.offset); err != nil {
+		return false, err
+	}
+	it.key, it.value = b.key, nil
+	if !it.keysOnly {
+		if it.value, err = it.db.decodeValue(b); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// reads a chunk of the file starting at the item
+// returns false if the item has been dropped from the file
+func (it *Iterator[T]) fill(item *iteratorItem[T]) (bool, error) {
+	db := it.db
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	select {
+	case <-db.closed:
+		return false, &DbInternalError{oper: "iterating", err: errors.New("database closed")}
+	default:
+	}
+	if db.generation != it.generation { // the file has been compacted
+		it.relocate()
+	}
+	if item.offset < 0 {
+		return false, nil
+	}
+
+	size := iteratorChunkSize
+	if header, err := readBlockHeader(db.reader(), item.offset); err == nil && int(header.Length) > size {
+		size = int(header.Length)
+	}
+	data := make([]byte, size)
+	n, err := db.reader().ReadAt(data, item.offset) // may be short at the end of the file
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, &DbInternalError{oper: "iterating", err: err}
+	}
+	it.chunk = chunk{offset: item.offset, data: data[:n]}
+	return true, nil
+}
+
+// looks up the current offsets of the items not visited yet, must be called with db.mtx read-locked
+// compaction keeps the order of the items, so they stay sorted
+func (it *Iterator[T]) relocate() {
+	for i := it.next - 1; i < len(it.items); i++ {
+		item := &it.items[i]
+		item.shard.mtx.RLock()
+		offset, ok := item.shard.blockOffsets[item.id]
+		item.shard.mtx.RUnlock()
+		if !ok {
+			offset = -1
+		}
+		item.offset = offset
+	}
+	it.chunk = chunk{}
+	it.generation = it.db.generation
+}
+
+// Returns the keys of all the items, in file order
+func (db *SimpleDb[T]) Keys() ([]string, error) {
+	it := db.Iterator()
+	it.keysOnly = true
+	keys := make([]string, 0, len(it.items))
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, it.Err()
+}
+
+// Calls fn for each item, in file order, stops at the first error returned by fn and returns it
+func (db *SimpleDb[T]) ForEach(fn func(key string, value *T) error) error {
+	it := db.Iterator()
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,97 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	const N = 2000 // a few chunks
+	DeleteDbFile("testIterator")
+	db, _ := Open[Person]("testIterator", WithFlushPolicy(FlushOnClose, 0))
+	defer db.Destroy()
+	for i := 0; i < N; i++ {
+		db.Put(fmt.Sprint("Person", i), &Person{Name: strings.Repeat("n", 100), Age: uint(i)})
+	}
+	db.Put("Big", &Person{Name: strings.Repeat("b", 2*iteratorChunkSize)})
+	for i := 0; i < N; i += 10 {
+		db.Delete(fmt.Sprint("Person", i))
+	}
+	db.Update("Person1", &Person{Name: "updated", Age: 1})
+
+	seen := make(map[string]bool)
+	prev := int64(-1)
+	it := db.Iterator()
+	for it.Next() {
+		if seen[it.Key()] {
+			t.Error("key visited twice: ", it.Key())
+		}
+		seen[it.Key()] = true
+		if offset := it.items[it.next-1].offset; offset <= prev {
+			t.Error("items should be visited in file order")
+		} else {
+			prev = offset
+		}
+		if it.Key() == "Person1" && it.Value().Name != "updated" {
+			t.Error("wrong value: ", it.Value())
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if len(seen) != db.Len() || !seen["Big"] || seen["Person10"] {
+		t.Error("wrong items visited: ", len(seen), db.Len())
+	}
+
+	keys, err := db.Keys()
+	if err != nil || len(keys) != db.Len() {
+		t.Error("wrong keys: ", len(keys), err)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = db.ForEach(func(key string, value *Person) error {
+		if visited++; visited == 5 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || visited != 5 {
+		t.Error("ForEach should stop at the callback's error", err, visited)
+	}
+}
+
+func TestIteratorCompaction(t *testing.T) {
+	const N = 2000
+	DeleteDbFile("testIteratorCompaction")
+	db, _ := Open[Person]("testIteratorCompaction")
+	defer db.Destroy()
+	for i := 0; i < N; i++ {
+		db.Put(fmt.Sprint("Person", i), &Person{Name: strings.Repeat("n", 100), Age: uint(i)})
+	}
+
+	it := db.Iterator()
+	visited := 0
+	for visited < N/2 && it.Next() {
+		visited++
+	}
+	db.Delete(fmt.Sprint("Person", N-1)) // not visited yet
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+		if it.Key() == fmt.Sprint("Person", N-1) {
+			t.Error("item dropped by compaction should be skipped")
+		}
+		if it.Value().Age != uint(visited) {
+			t.Error("wrong value after compaction: ", it.Key(), it.Value().Age)
+		}
+		visited++
+	}
+	if it.Err() != nil || visited != N-1 {
+		t.Error("wrong number of items visited: ", visited, it.Err())
+	}
+}
@@ -0,0 +1,82 @@
+package simpledb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const lockExt = ".lock" // lock file extension, appended to the db file name
+
+// advisory lock on the lock file of a database, shared by the read-only dbs opened on the same file in this process
+type fileLock struct {
+	path   string
+	file   *os.File
+	refs   int
+	shared bool
+}
+
+// locks held by this process, flock does not exclude opens within the same process
+var processLocks = struct {
+	sync.Mutex
+	held map[string]*fileLock
+}{held: make(map[string]*fileLock)}
+
+// locks the database file, exclusively for writers, or shared for readers
+// returns ErrLocked if another process, or another db of this process, holds a conflicting lock, or nil if there's nothing to lock
+func acquireLock(filePath string, shared bool, perm os.FileMode) (*fileLock, error) {
+	path, err := filepath.Abs(filePath + lockExt)
+	if err != nil {
+		return nil, err
+	}
+	processLocks.Lock()
+	defer processLocks.Unlock()
+
+	if l, ok := processLocks.held[path]; ok {
+		if !l.shared || !shared { // only readers may share the db, another writer would append through its own descriptor and index
+			return nil, ErrLocked
+		}
+		l.refs++
+		return l, nil
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if shared {
+		flag = os.O_RDONLY | os.O_CREATE
+	}
+	file, err := os.OpenFile(path, flag, perm)
+	if err != nil && shared { // the lock file can't be created, e.g. on a read-only filesystem
+		if file, err = os.Open(path); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil // no writer can have the db open, it would have created the lock file
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(file, shared); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &fileLock{path: path, file: file, refs: 1, shared: shared}
+	processLocks.held[path] = l
+	return l, nil
+}
+
+// releases the lock, the file gets unlocked when the last db of this process using it is closed
+// then the lock file is removed too, if remove is set
+func (l *fileLock) release(remove bool) error {
+	processLocks.Lock()
+	defer processLocks.Unlock()
+
+	if l.refs--; l.refs > 0 {
+		return nil
+	}
+	delete(processLocks.held, l.path)
+	if remove { // still locked, so nobody else gets to lock the removed file
+		os.Remove(l.path)
+	}
+	funlock(l.file)
+	return l.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package simpledb
+
+import "os"
+
+// advisory locks are not supported on this platform, only opens within the process are tracked
+func flock(file *os.File, shared bool) error {
+	return nil
+}
+
+func funlock(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build unix
+
+package simpledb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// holds the database open in a child process, until its stdin is closed
+func TestLockHelperProcess(t *testing.T) {
+	name := os.Getenv("SIMPLEDB_LOCK_DB")
+	if name == "" {
+		t.Skip("helper process")
+	}
+	var opts []Option
+	if os.Getenv("SIMPLEDB_LOCK_MODE") == "ro" {
+		opts = append(opts, WithReadOnly())
+	}
+	db, err := Open[Person](name, opts...)
+	if err != nil {
+		os.Stdout.WriteString("error " + err.Error() + "\n")
+		return
+	}
+	os.Stdout.WriteString("ready\n")
+	io.Copy(io.Discard, os.Stdin)
+	db.Close()
+}
+
+// opens the database in a child process, returns a func which closes it
+func holdInChild(t *testing.T, name, mode string) (release func()) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "SIMPLEDB_LOCK_DB="+name, "SIMPLEDB_LOCK_MODE="+mode)
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := bufio.NewReader(stdout).ReadString('\n')
+	if line != "ready\n" {
+		t.Fatalf("child failed to open the db: %q", line)
+	}
+	return func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+}
+
+func TestLockExclusive(t *testing.T) {
+	DeleteDbFile("testLock")
+	db, _ := Open[Person]("testLock")
+	db.Append("Person1", &testData[0])
+	db.Close()
+
+	release := holdInChild(t, "testLock", "rw")
+	if _, err := Open[Person]("testLock"); !errors.Is(err, ErrLocked) {
+		t.Errorf("db held by another process should not open, got %v", err)
+	}
+	if _, err := Open[Person]("testLock", WithReadOnly()); !errors.Is(err, ErrLocked) {
+		t.Errorf("db held by a writer should not open read-only, got %v", err)
+	}
+	release()
+
+	db, err := Open[Person]("testLock")
+	if err != nil {
+		t.Fatalf("db should open once the other process closes it: %v", err)
+	}
+	db.Destroy()
+	if _, err := os.Stat(db.filePath + lockExt); !os.IsNotExist(err) {
+		t.Error("lock file should be removed by Destroy")
+	}
+}
+
+func TestLockShared(t *testing.T) {
+	DeleteDbFile("testLockShared")
+	db, _ := Open[Person]("testLockShared")
+	db.Append("Person1", &testData[0])
+	db.Close()
+
+	release := holdInChild(t, "testLockShared", "ro")
+	defer release()
+	reader, err := Open[Person]("testLockShared", WithReadOnly())
+	if err != nil {
+		t.Fatalf("readers should share the db: %v", err)
+	}
+	if val, err := reader.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to read shared db", err)
+	}
+	reader.Close()
+	if _, err := Open[Person]("testLockShared"); !errors.Is(err, ErrLocked) {
+		t.Errorf("writer should not open a db being read by another process, got %v", err)
+	}
+}
+
+func TestLockSameProcess(t *testing.T) {
+	DeleteDbFile("testLockSame")
+	db, err := Open[Person]("testLockSame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open[Person]("testLockSame"); !errors.Is(err, ErrLocked) {
+		t.Errorf("second writer within the process should not open, got %v", err)
+	}
+	if _, err = Open[Person]("testLockSame", WithReadOnly()); !errors.Is(err, ErrLocked) {
+		t.Errorf("reader should not open a db written within the process, got %v", err)
+	}
+	db.Close()
+
+	reader1, err := Open[Person]("testLockSame", WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader2, err := Open[Person]("testLockSame", WithReadOnly())
+	if err != nil {
+		t.Fatalf("readers within the process should share the lock: %v", err)
+	}
+	if _, err = Open[Person]("testLockSame"); !errors.Is(err, ErrLocked) {
+		t.Errorf("writer should not open a db read within the process, got %v", err)
+	}
+	lock := reader1.lock
+	reader1.Close()
+	if _, held := processLocks.held[lock.path]; !held {
+		t.Error("lock should be held until the last db is closed")
+	}
+	reader2.Close()
+	if _, held := processLocks.held[lock.path]; held {
+		t.Error("lock should be released when the last db is closed")
+	}
+	DeleteDbFile("testLockSame")
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package simpledb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// places an advisory lock on the file, without blocking
+func flock(file *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+func funlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,226 @@
+package simpledb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kkonat/simpledb/hash"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultCacheSize = 1024
+
+// Option configures the database, options are passed to Open
+type Option func(*config) error
+
+type config struct {
+	cacheSize uint32         // max number of items in the read cache, 0 disables caching
+	shards    int            // number of partitions of the index and the read cache
+	hashAlg   hash.Algorithm // hash function used for key hashes
+	readOnly  bool
+	log       log.FieldLogger
+	codec     any     // Codec[T] for the db value type
+	codecId   CodecID // id of the codec in use, resolved on Open
+
+	compression       Compression // values are stored compressed, if not CompressNone
+	compressThreshold int         // values shorter than this are stored as they are
+
+	keyId uint32  // id of the key new blocks are sealed with
+	keys  KeyFunc // provides encryption keys, encryption is off if nil
+
+	dir  string      // directory of the database files, if empty it's DbPath next to the given filename
+	ext  string      // database file extension
+	perm os.FileMode // permissions of the files created
+
+	flushPolicy   FlushPolicy
+	flushEvery    int           // number of blocks after which the write buffer is flushed, for FlushEveryN
+	flushInterval time.Duration // the write buffer is flushed periodically, if non-zero
+	syncMode      SyncMode
+	syncInterval  time.Duration // group commit interval, for SyncGroup
+
+	compactRatio   float64 // automatic compaction is triggered when dead bytes exceed this share of the file, 0 disables it
+	compactMinSize int64   // files smaller than this are not compacted automatically
+}
+
+func defaultConfig() config {
+	return config{
+		cacheSize:   defaultCacheSize,
+		shards:      defaultShards,
+		hashAlg:     hash.CurrentAlgorithm(),
+		log:         log.StandardLogger(),
+		ext:         DbExt,
+		perm:        0600,
+		flushPolicy: FlushNone,
+		syncMode:    SyncNever,
+	}
+}
+
+// flags recorded in the header of a new database file
+func (c *config) fileFlags() (flags uint16) {
+	if c.keys != nil {
+		flags |= fileEncrypted
+	}
+	return flags
+}
+
+func (c *config) apply(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the max number of items in the read cache, 0 disables caching
+func WithCacheSize(size uint32) Option {
+	return func(c *config) error {
+		c.cacheSize = size
+		return nil
+	}
+}
+
+// Sets the number of partitions of the index and the read cache, writers of keys in different shards do not block each other
+func WithShards(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return errors.New("number of shards must be positive")
+		}
+		c.shards = n
+		return nil
+	}
+}
+
+// Sets the hash function used for key hashes, the one set in the hash package by default
+func WithHash(alg hash.Algorithm) Option {
+	return func(c *config) error {
+		if alg > hash.Superfast {
+			return errors.New("unknown hash algorithm")
+		}
+		c.hashAlg = alg
+		return nil
+	}
+}
+
+// Opens the database in read-only mode, writes are rejected with ErrReadOnly
+func WithReadOnly() Option {
+	return func(c *config) error {
+		c.readOnly = true
+		return nil
+	}
+}
+
+// Sets the logger for warnings, e.g. about recovery performed on open, logrus standard logger by default
+func WithLogger(logger log.FieldLogger) Option {
+	return func(c *config) error {
+		if logger == nil {
+			return errors.New("nil logger")
+		}
+		c.log = logger
+		return nil
+	}
+}
+
+// Sets the directory of the database files, instead of DbPath next to the filename passed to Open
+func WithDir(dir string) Option {
+	return func(c *config) error {
+		if dir == "" {
+			return errors.New("empty database directory")
+		}
+		c.dir = filepath.Clean(dir)
+		return nil
+	}
+}
+
+// Sets the extension of the database file, DbExt by default
+func WithExt(ext string) Option {
+	return func(c *config) error {
+		if strings.ContainsRune(ext, os.PathSeparator) {
+			return errors.New("invalid database file extension")
+		}
+		c.ext = ext
+		return nil
+	}
+}
+
+// Sets permissions of the database files, 0600 by default
+// directories created for the database get the execute bits along with the read bits
+func WithFileMode(perm os.FileMode) Option {
+	return func(c *config) error {
+		if perm&^os.ModePerm != 0 || perm&0600 != 0600 {
+			return errors.New("database files must be readable and writable by the owner")
+		}
+		c.perm = perm
+		return nil
+	}
+}
+
+// returns permissions for the directories created for the database
+func (c *config) dirMode() os.FileMode {
+	return c.perm | (c.perm&0444)>>2
+}
+
+// processes relative dirs and returns final filepath
+func (c *config) filepath(filename string) string {
+	if c.dir == "" {
+		return getFilepath(filename, c.ext)
+	}
+	return filepath.Join(c.dir, filepath.Clean(filename)+c.ext)
+}
+
+// Sets the write buffering policy, n is the number of blocks after which the buffer is flushed with FlushEveryN
+func WithFlushPolicy(policy FlushPolicy, n int) Option {
+	return func(c *config) error {
+		if policy < FlushNone || policy > FlushEveryN {
+			return errors.New("unknown flush policy")
+		}
+		if policy == FlushEveryN && n < 1 {
+			return errors.New("flush every N blocks requires N >= 1")
+		}
+		c.flushPolicy, c.flushEvery = policy, n
+		return nil
+	}
+}
+
+// Makes the write buffer flush periodically, in the background
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *config) error {
+		if interval < 0 {
+			return errors.New("flush interval must not be negative")
+		}
+		c.flushInterval = interval
+		return nil
+	}
+}
+
+// Sets the durability mode, interval is the group commit interval for SyncGroup
+func WithSyncMode(mode SyncMode, interval time.Duration) Option {
+	return func(c *config) error {
+		if mode < SyncNever || mode > SyncGroup {
+			return errors.New("unknown sync mode")
+		}
+		if mode == SyncGroup && interval <= 0 {
+			return errors.New("group commit requires a positive interval")
+		}
+		c.syncMode, c.syncInterval = mode, interval
+		return nil
+	}
+}
+
+// Enables automatic background compaction, triggered when the share of dead bytes in the file
+// (deleted or replaced items and markers) exceeds the ratio, and the file is at least minSize bytes long
+func WithAutoCompact(ratio float64, minSize int64) Option {
+	return func(c *config) error {
+		if ratio <= 0 || ratio >= 1 {
+			return errors.New("compaction ratio must be in (0, 1)")
+		}
+		if minSize < 0 {
+			return errors.New("compaction min size must not be negative")
+		}
+		c.compactRatio, c.compactMinSize = ratio, minSize
+		return nil
+	}
+}
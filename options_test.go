@@ -0,0 +1,136 @@
+package simpledb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/kkonat/simpledb/hash"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestNoCache(t *testing.T) {
+	DeleteDbFile("testNoCache")
+	db, err := OpenWithCache[Person]("testNoCache", 0) // used to panic
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Append("Person1", &testData[0])
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to get item", err)
+	}
+	if db.cachedItems() != 0 {
+		t.Error("nothing should be cached")
+	}
+	db.Destroy()
+}
+
+func TestHashOption(t *testing.T) {
+	DeleteDbFile("testHashOption")
+	db, _ := Open[Person]("testHashOption", WithHash(hash.Superfast))
+	db.Append("Person1", &testData[0])
+	keyHash := hash.Superfast.Get("Person1")
+	if _, ok := db.shardOf(keyHash).keyHashItems[keyHash]; !ok {
+		t.Error("key hash should be calculated with the db hash function")
+	}
+	db.Close()
+
+	if _, err := Open[Person]("testHashOption"); err == nil {
+		t.Error("file written with superfasthash should not open with crc32")
+	}
+	db, err := Open[Person]("testHashOption", WithHash(hash.Superfast))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to get item", err)
+	}
+	db.Destroy()
+}
+
+func TestReadOnlyOption(t *testing.T) {
+	DeleteDbFile("testReadOnlyOption")
+	db, _ := Open[Person]("testReadOnlyOption")
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1])
+	db.Delete("Person2")
+	crash(db) // not reorganized
+
+	var buff bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buff)
+	db, err := Open[Person]("testReadOnlyOption", WithReadOnly(), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err = db.Append("Person3", &testData[2]); !errors.Is(err, ErrReadOnly) {
+		t.Error("append should be rejected")
+	}
+	if err = db.Delete("Person1"); !errors.Is(err, ErrReadOnly) {
+		t.Error("delete should be rejected")
+	}
+	size := fileSize(db.filePath)
+	db.Close()
+	if fileSize(db.filePath) != size {
+		t.Error("file should not be reorganized")
+	}
+	DeleteDbFile("testReadOnlyOption")
+}
+
+func TestReadOnlyFile(t *testing.T) {
+	DeleteDbFile("testReadOnlyFile")
+	db, _ := Open[Person]("testReadOnlyFile")
+	db.Append("Person1", &testData[0])
+	db.Close()
+	f, _ := os.OpenFile(db.filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write([]byte{1, 2, 3}) // torn tail
+	f.Close()
+	os.Chmod(db.filePath, 0400)
+	size := fileSize(db.filePath)
+
+	db, err := Open[Person]("testReadOnlyFile", WithReadOnly())
+	if err != nil {
+		t.Fatalf("failed to open 0400 file read-only: %v", err)
+	}
+	if _, err = db.file.Write([]byte{0}); err == nil {
+		t.Error("file should be opened O_RDONLY")
+	}
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to get item", err)
+	}
+	if _, err = db.Update("Person1", &testData[1]); !errors.Is(err, ErrReadOnly) {
+		t.Error("update should be rejected")
+	}
+	if db.Recovery() == nil {
+		t.Error("torn tail should be reported")
+	}
+	db.Close()
+	if fileSize(db.filePath) != size {
+		t.Error("read-only file should not be truncated")
+	}
+	os.Chmod(db.filePath, 0600)
+	DeleteDbFile("testReadOnlyFile")
+
+	dir := t.TempDir() + "/missing"
+	if _, err = Open[Person]("testReadOnlyFile", WithReadOnly(), WithDir(dir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing db should not be created read-only, got %v", err)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("db dir should not be created read-only")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opt := range []Option{
+		WithHash(hash.Algorithm(100)),
+		WithLogger(nil),
+		WithAutoCompact(2, 0),
+		WithDir(""),
+		WithShards(0),
+	} {
+		if _, err := Open[Person]("testInvalidOptions", opt); err == nil {
+			t.Error("invalid option should be rejected")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package simpledb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// describes what has been discarded from the end of the database file when it was opened
+type RecoveryReport struct {
+	TruncatedAt    int64 // offset of the last good block boundary, the new file length, unless read-only
+	DiscardedBytes int64 // number of bytes cut off the end of the file
+	Reason         error // the error encountered while reading the trailing block
+}
+
+// checks if the error found at the given offset is caused by an incomplete or invalid trailing block,
+// i.e. a write torn by a crash, rather than a corruption in the middle of the file
+func isTornTail(file *os.File, offset, fileSize int64, err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) { // block runs past the end of file
+		return true
+	}
+	var corrupt *CorruptBlockError
+	if !errors.As(err, &corrupt) {
+		return false
+	}
+	// the block has a valid length, but its contents fail the checksum test: torn, if it's the last one
+	headerBytes := make([]byte, blockheadersSize())
+	if _, err := file.ReadAt(headerBytes, offset); err == nil {
+		var header blockHeader
+		header.setBytes(headerBytes)
+		if header.lengthOk() {
+			return offset+int64(header.Length) == fileSize
+		}
+	}
+	// the header itself is invalid: torn, if there's nothing but zeros till the end of file
+	// (some filesystems extend files with zeroed pages, when a crash happens during a write)
+	return zerosTill(file, offset, fileSize)
+}
+
+// size of the reads checking the tail of the file
+const tailChunkSize = 64 * 1024
+
+// checks if there's nothing but zeros from the offset till the end of file, reading it in chunks
+func zerosTill(file io.ReaderAt, offset, fileSize int64) bool {
+	buff := make([]byte, tailChunkSize)
+	for offset < fileSize {
+		n := int64(len(buff))
+		if rest := fileSize - offset; rest < n {
+			n = rest
+		}
+		if _, err := file.ReadAt(buff[:n], offset); err != nil {
+			return false
+		}
+		if len(bytes.Trim(buff[:n], "\x00")) != 0 {
+			return false
+		}
+		offset += n
+	}
+	return true
+}
+
+// truncates the database file back to the last good block boundary
+// a read-only file is not truncated, the tail is just skipped
+func (db *SimpleDb[T]) truncateTail(offset, fileSize int64, reason error) error {
+	if !db.cfg.readOnly {
+		if err := db.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	db.recovery = &RecoveryReport{
+		TruncatedAt:    offset,
+		DiscardedBytes: fileSize - offset,
+		Reason:         reason,
+	}
+	db.cfg.log.Warnf("simpledb: %s: discarded %d bytes of an incomplete block at offset %d: %v",
+		db.filePath, fileSize-offset, offset, reason)
+	return nil
+}
+
+// Returns the report of the recovery performed when the database was opened, or nil if the file was intact
+func (db *SimpleDb[T]) Recovery() *RecoveryReport {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.recovery
+}
@@ -0,0 +1,126 @@
+package simpledb
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// a key, value pair returned by range scans
+type Item[T any] struct {
+	Key   string
+	Value *T
+}
+
+// Marks the position of a range scan, to resume it with the next page of items
+// it's made of the last key returned, so it stays valid when the db is modified between the calls
+// an empty cursor means there are no more items
+type Cursor string
+
+func newCursor(key string) Cursor {
+	return Cursor(base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+func (c Cursor) key() (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(string(c))
+	return string(key), err
+}
+
+type scan struct {
+	reverse bool
+	limit   int
+	after   *string // the key the scan resumes after
+}
+
+// configures a range scan
+type ScanOption func(s *scan) error
+
+// Returns the items in descending key order
+func Reverse() ScanOption {
+	return func(s *scan) error {
+		s.reverse = true
+		return nil
+	}
+}
+
+// Returns at most n items, along with the cursor of the next page, 0 means no limit
+func Limit(n int) ScanOption {
+	return func(s *scan) error {
+		if n < 0 {
+			return errors.New("limit must not be negative")
+		}
+		s.limit = n
+		return nil
+	}
+}
+
+// Resumes the scan after the position returned by the previous call, the scan has to have the same order
+func After(c Cursor) ScanOption {
+	return func(s *scan) error {
+		if c == "" {
+			return nil
+		}
+		key, err := c.key()
+		if err != nil {
+			return errors.New("invalid cursor")
+		}
+		s.after = &key
+		return nil
+	}
+}
+
+// Returns the items with keys in the range [start, end), in key order, an empty end means no upper bound
+// with Limit, the returned cursor may be passed with After to get the next page
+// the items of a page are read with all the shards read-locked, so they include a batch either whole or not at all,
+// while writers wait, so large pages are better read with Limit
+func (db *SimpleDb[T]) Range(start, end string, opts ...ScanOption) (items []Item[T], next Cursor, err error) {
+	var s scan
+	for _, opt := range opts {
+		if err = opt(&s); err != nil {
+			return nil, "", &DbGeneralError{err: "range: " + err.Error()}
+		}
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	db.rLockShards()
+	defer db.rUnlockShards()
+
+	keys, more := db.keys.keys(start, end, s.reverse, s.after, s.limit)
+	items = make([]Item[T], 0, len(keys))
+	for _, key := range keys {
+		keyHash := db.keyHash(key)
+		sh := db.shardOf(keyHash)
+		id, found, err := db.findItem(sh, key, keyHash)
+		if err != nil {
+			return nil, "", err
+		}
+		if !found { // the index and the shards are updated together, so it should be there
+			continue
+		}
+		_, value, err := db.getShardItem(sh, id)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, Item[T]{Key: key, Value: value})
+	}
+	if more {
+		next = newCursor(keys[len(keys)-1])
+	}
+	return items, next, nil
+}
+
+// Returns the items with keys starting with the prefix, in key order, options as in Range
+func (db *SimpleDb[T]) Prefix(prefix string, opts ...ScanOption) ([]Item[T], Cursor, error) {
+	return db.Range(prefix, prefixEnd(prefix), opts...)
+}
+
+// returns the first key after all the keys with the given prefix, or an empty string if there's no such key
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package simpledb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func scanKeys[T any](items []Item[T]) (keys []string) {
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+func TestRangeScan(t *testing.T) {
+	DeleteDbFile("testRangeScan")
+	db, _ := Open[Person]("testRangeScan")
+	for i := 0; i < 10; i++ {
+		db.Put(fmt.Sprintf("user:%d:", i), &Person{Age: uint(i)})
+	}
+	db.Put("users", &testData[0])
+	db.Put("user", &testData[1])
+	db.Delete("user:5:")
+	db.Close()
+
+	db, _ = Open[Person]("testRangeScan") // the index is rebuilt from the file
+	defer db.Destroy()
+	items, next, err := db.Range("user:2:", "user:7:")
+	if err != nil || next != "" || fmt.Sprint(scanKeys(items)) != "[user:2: user:3: user:4: user:6:]" {
+		t.Error("wrong range: ", scanKeys(items), err)
+	}
+	if items[0].Value.Age != 2 {
+		t.Error("wrong value: ", items[0].Value)
+	}
+	items, _, _ = db.Prefix("user:", Reverse())
+	if fmt.Sprint(scanKeys(items)) != "[user:9: user:8: user:7: user:6: user:4: user:3: user:2: user:1: user:0:]" {
+		t.Error("wrong reverse prefix scan: ", scanKeys(items))
+	}
+
+	// page through the prefix, while it's being modified
+	var keys []string
+	var page []Item[Person]
+	next = ""
+	for first := true; first || next != ""; first = false {
+		if page, next, err = db.Prefix("user:", Limit(3), After(next)); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, scanKeys(page)...)
+		if first {
+			db.Delete("user:1:") // already returned
+			db.Delete("user:4:")
+			db.Put("user:45:", &testData[2])
+		}
+	}
+	if fmt.Sprint(keys) != "[user:0: user:1: user:2: user:3: user:45: user:6: user:7: user:8: user:9:]" {
+		t.Error("wrong pages: ", keys)
+	}
+
+	if _, _, err = db.Range("", "", After("!")); err == nil {
+		t.Error("invalid cursor should be rejected")
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, end := range map[string]string{"abc": "abd", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if prefixEnd(prefix) != end {
+			t.Errorf("wrong prefix end of %q: %q", prefix, prefixEnd(prefix))
+		}
+	}
+}
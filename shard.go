@@ -0,0 +1,160 @@
+package simpledb
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kkonat/simpledb/hash"
+)
+
+const defaultShards = 16
+
+// a partition of the index and of the read cache, items are assigned to shards by their key hash,
+// so that writers of unrelated keys do not block each other
+type shard[T any] struct {
+	mtx sync.RWMutex // read-locked by Get, locked by the operations modifying the shard's items
+
+	toBeDeleted  map[ID]Flag        // items marked for deletion
+	blockOffsets map[ID]int64       // items' ofssets in the file
+	keyHashItems map[hash.Type][]ID // to quickly find IDs of items with the given key hash
+	readCache    *cache[T]
+}
+
+// creates n empty shards, sharing the read cache size
+func newShards[T any](n int, cacheSize uint32) []*shard[T] {
+	caches := newCaches[T](n, cacheSize)
+	shards := make([]*shard[T], n)
+	for i := range shards {
+		shards[i] = &shard[T]{readCache: caches[i]}
+		shards[i].reset()
+	}
+	return shards
+}
+
+// empties the shard's index
+func (s *shard[T]) reset() {
+	s.toBeDeleted = make(map[ID]Flag)
+	s.blockOffsets = make(map[ID]int64)
+	s.keyHashItems = make(map[hash.Type][]ID)
+}
+
+// checks if the shard contains an element with the given ID
+func (s *shard[T]) contains(id ID) (ok bool) {
+	_, ok = s.blockOffsets[id]
+	return
+}
+
+// returns the shard of the items with the given key hash
+func (db *SimpleDb[T]) shardOf(keyHash hash.Type) *shard[T] {
+	return db.shards[db.shardIndex(keyHash)]
+}
+
+func (db *SimpleDb[T]) shardIndex(keyHash hash.Type) int {
+	return int(uint32(keyHash) % uint32(len(db.shards)))
+}
+
+// returns the shard holding the item with the given id, or the first one, if there's no such item
+func (db *SimpleDb[T]) shardOfId(id ID) *shard[T] {
+	for _, s := range db.shards {
+		s.mtx.RLock()
+		ok := s.contains(id)
+		s.mtx.RUnlock()
+		if ok {
+			return s
+		}
+	}
+	return db.shards[0]
+}
+
+// read-locks the db and locks the shard of the key hash, for modifying its items
+func (db *SimpleDb[T]) lockShard(keyHash hash.Type) *shard[T] {
+	db.mtx.RLock()
+	s := db.shardOf(keyHash)
+	s.mtx.Lock()
+	return s
+}
+
+// read-locks the db and locks the shards of the key hashes, in the order of the shards, so that writers do not deadlock
+func (db *SimpleDb[T]) lockShards(keyHashes []hash.Type) []*shard[T] {
+	db.mtx.RLock()
+	indexes := make([]int, 0, len(keyHashes))
+	seen := make(map[int]bool, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		if i := db.shardIndex(keyHash); !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	shards := make([]*shard[T], len(indexes))
+	for i, index := range indexes {
+		shards[i] = db.shards[index]
+		shards[i].mtx.Lock()
+	}
+	return shards
+}
+
+// read-locks all the shards, in the order of the shards, as writers lock them, so that the view is consistent with batches
+// must be called with db.mtx locked or read-locked
+func (db *SimpleDb[T]) rLockShards() {
+	for _, s := range db.shards {
+		s.mtx.RLock()
+	}
+}
+
+func (db *SimpleDb[T]) rUnlockShards() {
+	for _, s := range db.shards {
+		s.mtx.RUnlock()
+	}
+}
+
+// returns the ids of the items marked for deletion in all the shards, must be called with db.mtx locked
+func (db *SimpleDb[T]) deletedItems() map[ID]Flag {
+	deleted := make(map[ID]Flag)
+	for _, s := range db.shards {
+		for id := range s.toBeDeleted {
+			deleted[id] = Flag{}
+		}
+	}
+	return deleted
+}
+
+// updates the items' offsets after the file has been compacted, must be called with db.mtx locked
+// items before the end offset have been moved to the given offsets, the ones after it are shifted, dropped items are removed
+func (db *SimpleDb[T]) relocate(moved map[ID]int64, end, shift int64, dropped map[ID]Flag) {
+	db.generation++ // iterators have to look the offsets up again
+	for _, s := range db.shards {
+		for id, offset := range s.blockOffsets {
+			switch _, drop := dropped[id]; {
+			case drop:
+				delete(s.blockOffsets, id)
+				delete(s.toBeDeleted, id)
+			case offset >= end:
+				s.blockOffsets[id] = offset + shift
+			default:
+				s.blockOffsets[id] = moved[id]
+			}
+		}
+	}
+}
+
+// returns the hit rate of the read cache, in percent
+func (db *SimpleDb[T]) cacheHitRate() float64 {
+	var requests, hits uint64
+	for _, s := range db.shards {
+		requests += s.readCache.requests()
+		hits += s.readCache.hits()
+	}
+	if requests == 0 {
+		return 0
+	}
+	return float64(hits) / float64(requests) * 100
+}
+
+// returns the number of items in the read cache
+func (db *SimpleDb[T]) cachedItems() (n int) {
+	for _, s := range db.shards {
+		n += s.readCache.len()
+	}
+	return n
+}
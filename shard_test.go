@@ -0,0 +1,40 @@
+package simpledb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardCountChange(t *testing.T) {
+	const N = 100
+	DeleteDbFile("testShards")
+	db, _ := Open[Person]("testShards", WithShards(4))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < N; i += 4 {
+				db.Append(fmt.Sprint("Person", i), &Person{Name: fmt.Sprint("Name", i), Age: uint(i)})
+			}
+		}(w)
+	}
+	wg.Wait()
+	db.Close()
+
+	// the hint was written for 4 shards, so the index is rebuilt from the data file
+	db, err := Open[Person]("testShards", WithShards(3))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Destroy()
+	if db.ItemsCount != N {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	for i := 0; i < N; i++ {
+		if p, err := db.Get(fmt.Sprint("Person", i)); err != nil || p.Age != uint(i) {
+			t.Error("failed to get item", i, err)
+		}
+	}
+}
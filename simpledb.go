@@ -1,15 +1,16 @@
 package simpledb
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	"github.com/kkonat/simpledb/hash"
 
-	"github.com/near/borsh-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -29,161 +30,350 @@ type ID uint32 // this is small database, so let's assume it may hold "only" 4 b
 type SimpleDb[T any] struct {
 	filePath string
 	file     *os.File
-
-	mtx sync.RWMutex
-
-	readCache *cache[T]
-
-	ItemsCount    int   // number of items in the db
-	currentOffset int64 // as blocks may be up to  4GB long, the file length/index must be at least uint64
-	maxId         ID    // maximum ID value, used for Item ID generation
-
-	toBeDeleted  map[ID]Flag        // items marked for deletion
-	blockOffsets map[ID]int64       // items' ofssets in the file
-	keyHashItems map[hash.Type][]ID // to quickly find IDs of items with the given key hash
+	lock     *fileLock    // advisory lock held while the db is open
+	writer   *blockWriter // appends blocks to the file, possibly buffering them
+	syncer   *groupSyncer // group commit state, for SyncGroup mode
+
+	cfg    config
+	closed chan struct{} // closed when the db gets closed, stops background goroutines
+
+	mtx        sync.RWMutex // read-locked by the operations on items, locked by the ones on the whole db, e.g. Close
+	appendMtx  sync.Mutex   // serializes appends to the file
+	compactMtx sync.Mutex   // held while the file is being compacted
+
+	shards []*shard[T] // the index and the read cache, partitioned by key hash
+	keys   *skiplist   // ordered index of the keys, for range scans
+	codec  Codec[T]
+	sealer *sealer // encrypts blocks, nil if encryption is off
+
+	// number of items in the db, updated atomically
+	//
+	// Deprecated: use Len, which is safe for concurrent use
+	ItemsCount    int64
+	currentOffset int64  // as blocks may be up to  4GB long, the file length/index must be at least uint64, guarded by appendMtx
+	maxId         ID     // maximum ID value, used for Item ID generation, guarded by appendMtx
+	deadBytes     int64  // bytes taken by deleted or replaced items and markers, reclaimed by compaction, updated atomically
+	generation    uint64 // incremented whenever items are moved within the file, guarded by mtx
+
+	recovery *RecoveryReport // what has been discarded from the file on open, if anything
 }
 
-// creates a new database or opens an existing one
-func Open[T any](filename string, cacheSize uint32) (db *SimpleDb[T], err error) {
-
-	if cacheSize < 1 {
-		panic("cache size must be non-zero")
-	}
+// creates a new database or opens an existing one, configured with the options
+func Open[T any](filename string, opts ...Option) (db *SimpleDb[T], err error) {
 
 	db = &SimpleDb[T]{
-		filePath:     getFilepath(filename),
-		readCache:    newCache[T](cacheSize),
-		keyHashItems: make(map[hash.Type][]ID),
-		toBeDeleted:  make(map[ID]Flag),
+		cfg:    defaultConfig(),
+		closed: make(chan struct{}),
+	}
+	if err = db.cfg.apply(opts); err != nil {
+		return nil, &DbGeneralError{err: "open: " + err.Error()}
 	}
+	if db.codec, err = codecFor[T](&db.cfg); err != nil {
+		return nil, &DbGeneralError{err: "open: " + err.Error()}
+	}
+	db.cfg.codecId = db.codec.ID()
+	db.filePath = db.cfg.filepath(filename)
+	db.shards = newShards[T](db.cfg.shards, db.cfg.cacheSize)
+	db.keys = newSkiplist()
+	db.sealer = newSealer(&db.cfg)
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
-	if _, err = os.Stat(DbPath); err != nil { // create subdir if does not exist
-		os.Mkdir(DbPath, 0700)
+	if !db.cfg.readOnly {
+		if err = os.MkdirAll(filepath.Dir(db.filePath), db.cfg.dirMode()); err != nil { // create dir if does not exist
+			return nil, &DbInternalError{oper: "creating db dir", err: err}
+		}
 	}
-	if _, err = os.Stat(db.filePath); err == nil { // if db file exists
-		if db.file, err = openFile(db.filePath); err != nil {
-			return nil, &DbGeneralError{err: "open"}
+	lock, err := acquireLock(db.filePath, db.cfg.readOnly, db.cfg.perm)
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			return nil, err
 		}
-
-		if err = db.loadDb(); err != nil {
-			return nil, &DbInternalError{oper: "reading db", err: err}
+		return nil, &DbInternalError{oper: "locking db", err: err}
+	}
+	defer func() {
+		if err != nil && lock != nil {
+			lock.release(false)
 		}
-	} else { // if not, initialize empty db
-		db.blockOffsets = make(map[ID]int64)
-		db.file, err = openFile(db.filePath)
+	}()
+	db.lock = lock
+	if !db.cfg.readOnly { // a read-only db is left as it is
+		if err = recoverCompaction(db.filePath, db.cfg.log); err != nil {
+			return nil, &DbInternalError{oper: "recovering compaction", err: err}
+		}
+	}
+	if err = db.upgradeLegacy(); err != nil {
+		if errors.Is(err, ErrLegacyFile) {
+			return nil, err
+		}
+		return nil, &DbInternalError{oper: "upgrading legacy file", err: err}
+	}
+	if db.file, err = openFile(db.filePath, db.cfg.perm, db.cfg.readOnly); err != nil { // creates the file if it does not exist
+		return nil, &DbInternalError{oper: "opening db file", err: err}
+	}
+	if err = db.initFileHeader(); err != nil {
+		db.file.Close()
+		return nil, &DbInternalError{oper: "reading file header", err: err}
+	}
+	if err = db.loadDb(); err != nil {
+		db.file.Close()
+		return nil, &DbInternalError{oper: "reading db", err: err}
+	}
+	if err = db.checkKeys(); err != nil {
+		db.file.Close()
+		return nil, err
+	}
+	db.writer = newBlockWriter(db.file, db.currentOffset, &db.cfg)
+	if db.cfg.readOnly {
+		return
+	}
+	if db.cfg.flushPolicy != FlushNone && db.cfg.flushInterval > 0 {
+		go db.flushPeriodically(db.cfg.flushInterval)
+	}
+	if db.cfg.syncMode == SyncGroup {
+		db.syncer = newGroupSyncer()
+		go db.syncPeriodically(db.cfg.syncInterval)
 	}
 	return
 }
 
+// creates a new database or opens an existing one, with the given read cache size
+// kept for compatibility, Open with WithCacheSize does the same
+func OpenWithCache[T any](filename string, cacheSize uint32) (*SimpleDb[T], error) {
+	return Open[T](filename, WithCacheSize(cacheSize))
+}
+
 // Closes db and Removes the database file from disk, permanently and irreversibly
 func (db *SimpleDb[T]) Destroy() (err error) {
+	db.compactMtx.Lock()
+	defer db.compactMtx.Unlock()
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
+	db.stopBackground()
+	db.abortSyncs()
 	db.file.Close()
+	defer db.releaseLock(true)
 	if err = os.Remove(db.filePath); err != nil {
 		return &DbInternalError{oper: "removing datafile", err: err}
 	}
+	if err = db.removeHint(); err != nil {
+		return &DbInternalError{oper: "removing hint file", err: err}
+	}
+	os.Remove(db.filePath + compactExt) // left by an interrupted compaction, if any
 	return
 }
 
-// Forcefully deletes database file from disk
-func DeleteDbFile(file string) error {
-	path := getFilepath(file)
+// releases the advisory lock on the db, may be called more than once
+func (db *SimpleDb[T]) releaseLock(remove bool) {
+	if db.lock != nil {
+		if err := db.lock.release(remove); err != nil {
+			db.cfg.log.Warnf("simpledb: %s: releasing lock: %v", db.filePath, err)
+		}
+		db.lock = nil
+	}
+}
+
+// Forcefully deletes database file from disk, options locate the file as in Open
+func DeleteDbFile(file string, opts ...Option) error {
+	cfg := defaultConfig()
+	if err := cfg.apply(opts); err != nil {
+		return err
+	}
+	path := cfg.filepath(file)
+	os.Remove(path + hintExt)
+	os.Remove(path + compactExt)
 	return os.Remove(path)
 }
 
 // Appends a key, value pair to the database, returns added block id, and error, if any
+// it replaces the value of an existing key, as Put does, so that keys stay unique
+//
+// Deprecated: use Put, or Insert to fail on existing keys
 func (db *SimpleDb[T]) Append(key string, value *T) (id ID, err error) {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
-	return db.appendItem(key, value)
+	return db.Put(key, value)
 }
 
-func (db *SimpleDb[T]) appendItem(key string, value *T) (id ID, err error) {
+// Puts a key, value pair into the database, replacing the value if the key exists, returns the id of the new item
+func (db *SimpleDb[T]) Put(key string, value *T) (id ID, err error) {
+	if db.cfg.readOnly {
+		return 0, ErrReadOnly
+	}
+	keyHash := db.keyHash(key)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
 
-	id = db.genNewId()
-	keyHash := hash.Get(key)
+	oldId, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return db.replaceItem(s, oldId, key, keyHash, value)
+	}
+	return db.appendItem(s, key, value)
+}
+
+// Inserts a new key, value pair into the database, fails with ErrKeyExists if the key exists
+func (db *SimpleDb[T]) Insert(key string, value *T) (id ID, err error) {
+	if db.cfg.readOnly {
+		return 0, ErrReadOnly
+	}
+	keyHash := db.keyHash(key)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
+
+	_, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return 0, ErrKeyExists
+	}
+	return db.appendItem(s, key, value)
+}
+
+func (db *SimpleDb[T]) appendItem(s *shard[T], key string, value *T) (id ID, err error) {
+	return db.writeItem(s, db.genNewId(), key, value, nil)
+}
+
+// Writes the item together with an optional marker preceding it, in a single write,
+// so that the marker is never persisted without the item it refers to
+// the item is encoded before the file is locked for appending, so the shards' writers encode in parallel
+func (db *SimpleDb[T]) writeItem(s *shard[T], id ID, key string, value *T, marker *block) (ID, error) {
+	keyHash := db.keyHash(key)
+	item, err := db.encodeItem(id, key, keyHash, value)
+	if err != nil {
+		return 0, err
+	}
+
+	var buff []byte
+	var dead int64
+	if marker != nil {
+		buff = marker.getBytes()
+		dead = int64(len(buff))
+	}
+	itemAt := int64(len(buff))
+	buff = append(buff, item...)
+
+	offset, err := db.appendBlocks(buff, dead)
+	if err != nil {
+		return 0, err
+	}
 
 	// Cache the newly added item in readCache
-	cacheItem := &cacheItem[T]{
-		id:      ID(id),
+	s.readCache.add(&cacheItem[T]{
+		id:      id,
 		key:     key,
 		keyHash: keyHash,
 		value:   value,
-	}
-	db.readCache.add(cacheItem)
+	})
+
+	db.indexItem(s, id, keyHash, offset+itemAt)
+	db.keys.put(key, id)
+	return id, db.synchronize()
+}
 
-	// Cache the newly added item in writeCache
-	srlzdValue, err := borsh.Serialize(value)
+// encodes the item block, serializing, compressing and encrypting the value as configured
+func (db *SimpleDb[T]) encodeItem(id ID, key string, keyHash hash.Type, value *T) ([]byte, error) {
+	srlzdValue, err := db.codec.Marshal(value)
 	if err != nil {
-		panic("todo: handle serialization failure")
+		return nil, &DbInternalError{oper: "serializing", err: err}
+	}
+	block := newBlock(id, key, keyHash, srlzdValue)
+	if err = db.compressBlock(block); err != nil {
+		return nil, &DbInternalError{oper: "compressing", err: err}
+	}
+	if db.sealer != nil {
+		if err = db.sealer.seal(block); err != nil {
+			return nil, &DbInternalError{oper: "encrypting", err: err}
+		}
 	}
-	block := NewBlock(id, key, srlzdValue)
+	return block.getBytes(), nil
+}
 
-	w, err := db.file.Write(block.getBytes())
-	if err != nil {
-		return 0, err
+// Writes a marker block at the end of the file
+func (db *SimpleDb[T]) writeMarker(marker *block) error {
+	buff := marker.getBytes()
+	if _, err := db.appendBlocks(buff, int64(len(buff))); err != nil { // markers are dead from the start
+		return err
 	}
+	return db.synchronize()
+}
 
-	db.blockOffsets[id] = db.currentOffset
+// appends encoded blocks at the end of the file, the only place the file is written to while the db is open
+// returns the offset they've been written at, dead is the number of bytes taken by markers
+func (db *SimpleDb[T]) appendBlocks(buff []byte, dead int64) (offset int64, err error) {
+	db.appendMtx.Lock()
+	defer db.appendMtx.Unlock()
+
+	offset = db.currentOffset
+	w, err := db.writer.write(buff)
+	if w < len(buff) {
+		return 0, err
+	}
+	if err != nil { // the blocks are buffered and get written by the next flush, so the write has not failed
+		db.cfg.log.Warnf("simpledb: %s: flushing: %v", db.filePath, err)
+	}
 	db.currentOffset += int64(w)
-	db.ItemsCount++
+	atomic.AddInt64(&db.deadBytes, dead)
+	if db.syncer != nil {
+		db.syncer.written++
+	}
+	return offset, nil
+}
 
-	if db.keyHashItems[keyHash] == nil {
-		db.keyHashItems[keyHash] = make([]ID, 16)
+// adds the item to the shard's offsets map and key hash map
+func (db *SimpleDb[T]) indexItem(s *shard[T], id ID, keyHash hash.Type, offset int64) {
+	s.blockOffsets[id] = offset
+	if s.keyHashItems[keyHash] == nil {
+		s.keyHashItems[keyHash] = make([]ID, 0, 16)
 	}
-	db.keyHashItems[keyHash] = append(db.keyHashItems[keyHash], id)
-	return id, nil
+	s.keyHashItems[keyHash] = append(s.keyHashItems[keyHash], id)
+	atomic.AddInt64(&db.ItemsCount, 1)
 }
 
 // Gets one key, value pair from the database for the given Id
-// This is an internal function,
+// This is an internal function, used for testing,
 // Id is also an internal idenifier which  may change on subsequent item updates
 func (db *SimpleDb[T]) getItem(id ID) (key string, value *T, err error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	s := db.shardOfId(id)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return db.getShardItem(s, id)
+}
+
+// Gets one key, value pair for the given Id from the shard, which must be locked or read-locked
+func (db *SimpleDb[T]) getShardItem(s *shard[T], id ID) (key string, value *T, err error) {
 
-	if _, ok := db.toBeDeleted[id]; ok {
+	if _, ok := s.toBeDeleted[id]; ok {
 		return "", nil, &NotFoundError{id: id}
 	}
 
-	if object, exists := db.readCache.getIfExists(id); exists {
-		db.readCache.touch(id) // if it's in the read cache, mark it as recently accessed
+	if object, exists := s.readCache.get(id); exists { // if it's in the read cache, it's marked as recently accessed
 		return object.key, object.value, nil
 	}
 
-	if !db.contains(id) { // re-check if it is now in the file
+	if !s.contains(id) { // re-check if it is now in the file
 		return "", nil, &NotFoundError{id: id}
 	}
 	// if it is, read it from the  file
-	offset := db.blockOffsets[id]
+	offset := s.blockOffsets[id]
 
-	// read item from the file
-	db.file.Seek(int64(offset), io.SeekStart) // seek to the item  position in the file
-	header := blockHeader{}
-
-	if err = header.read(db.file); err != nil { // read OffsL bytes
-		return "", nil, err
-	}
-	buff := make([]byte, header.Length)
-
-	db.file.Seek(int64(offset), io.SeekStart)
-	if _, err = db.file.Read(buff); err != nil {
+	// read item from the file, verifying its checksum
+	block, err := db.readItemBlock(offset)
+	if err != nil {
 		return "", nil, err
 	}
-	block := &block{}
-	block.setBytes(buff)
 
 	key = block.key
-	value = new(T)
-	// unmarshall payload
-	if err := borsh.Deserialize(&value, block.value); err != nil {
-		return "", nil, &DbInternalError{oper: "deserializing", err: err}
+	if value, err = db.decodeValue(block); err != nil {
+		return "", nil, err
 	}
 
 	// create db Item for caching
-	db.readCache.add(&cacheItem[T]{
+	s.readCache.add(&cacheItem[T]{
 		id:      ID(block.Id),
 		keyHash: block.KeyHash,
 		key:     key,
@@ -192,200 +382,291 @@ func (db *SimpleDb[T]) getItem(id ID) (key string, value *T, err error) {
 	return
 }
 
+// decompresses and unmarshalls the value of an item block
+func (db *SimpleDb[T]) decodeValue(block *block) (*T, error) {
+	payload, err := block.rawValue()
+	if err != nil {
+		return nil, &DbInternalError{oper: "decompressing", err: err}
+	}
+	value := new(T)
+	if err := db.codec.Unmarshal(payload, value); err != nil {
+		return nil, &DbInternalError{oper: "deserializing", err: err}
+	}
+	return value, nil
+}
+
 // Gets a value for the given key
 func (db *SimpleDb[T]) Get(key string) (val *T, err error) {
 	db.mtx.RLock()
 	defer db.mtx.RUnlock()
 
 	var candidateKey string
-	keyHash := hash.Get(key)
+	keyHash := db.keyHash(key)
+	s := db.shardOf(keyHash)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
 
-	idCandidates, ok := db.keyHashItems[keyHash]
+	idCandidates, ok := s.keyHashItems[keyHash]
 	if !ok {
 		return nil, &NotFoundError{}
 	}
 
 	for _, candidate := range idCandidates {
-		candidateKey, val, err = db.getItem(candidate) // get actual keys
+		candidateKey, val, err = db.getShardItem(s, candidate) // get actual keys
 		if err == nil && candidateKey == key {
 			return val, nil
 		}
+		if isCorrupt(err) {
+			return nil, err
+		}
 	}
 	return nil, &NotFoundError{}
 }
 
-// Updates the value for the given key
-func (db *SimpleDb[T]) Update(key string, value *T) (id ID, err error) {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
+// Checks if there's an item with the given key, only the stored keys are compared, the values are not read
+func (db *SimpleDb[T]) Has(key string) (bool, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	keyHash := db.keyHash(key)
+	s := db.shardOf(keyHash)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
 
-	keyHash := hash.Get(key)
+	for _, candidate := range s.keyHashItems[keyHash] {
+		candidateKey, err := db.itemKey(s, candidate)
+		if err != nil {
+			return false, err
+		}
+		if candidateKey == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the number of items in the db
+func (db *SimpleDb[T]) Len() int {
+	return int(atomic.LoadInt64(&db.ItemsCount))
+}
 
-	idCandidates, ok := db.keyHashItems[keyHash]
+// returns the key of the item, from the cache or reading just the key from the file, the shard must be locked or read-locked
+// the block's checksum is not verified, it covers the value as well, so it's checked when the value is read
+func (db *SimpleDb[T]) itemKey(s *shard[T], id ID) (string, error) {
+	if item, ok := s.readCache.getIfExists(id); ok {
+		return item.key, nil
+	}
+	offset, ok := s.blockOffsets[id]
 	if !ok {
+		return "", &NotFoundError{id: id}
+	}
+	header, key, err := readBlockKey(db.reader(), offset)
+	if err != nil {
+		return "", err
+	}
+	if header.Flags&flagEncrypted != 0 { // the key is sealed along with the value
+		block, err := db.readItemBlock(offset)
+		if err != nil {
+			return "", err
+		}
+		return block.key, nil
+	}
+	return key, nil
+}
+
+// Updates the value for the given key, fails with NotFoundError if the key does not exist
+func (db *SimpleDb[T]) Update(key string, value *T) (id ID, err error) {
+	if db.cfg.readOnly {
+		return 0, ErrReadOnly
+	}
+	keyHash := db.keyHash(key)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
+
+	oldId, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
 		return 0, &NotFoundError{}
 	}
+	return db.replaceItem(s, oldId, key, keyHash, value)
+}
 
-	// find and delete old key,value pair
-	for _, candidate := range idCandidates {
-		candidateKey, _, err := db.getItem(candidate)
-		if err == nil && key == candidateKey {
-			db.deleteById(candidate, keyHash)
-			break
+// finds the live item with the given key in the shard, which must be locked or read-locked
+func (db *SimpleDb[T]) findItem(s *shard[T], key string, keyHash hash.Type) (id ID, found bool, err error) {
+	for _, candidate := range s.keyHashItems[keyHash] {
+		candidateKey, _, err := db.getShardItem(s, candidate) // get actual keys
+		if isCorrupt(err) {
+			return 0, false, err
+		}
+		if err == nil && candidateKey == key {
+			return candidate, true, nil
 		}
 	}
+	return 0, false, nil
+}
 
-	// add themodified key,value pair as a new db Item
-	id, err = db.appendItem(key, value)
-
-	// Update deletes the old and addsthe new item do db, and to the cache so it's automatically cached, and the freshest in the cache
-	return id, err
+// writes the new value of the key, together with a marker superseding the old item, then drops the old one
+// the new item is cached, so it's the freshest in the cache
+func (db *SimpleDb[T]) replaceItem(s *shard[T], oldId ID, key string, keyHash hash.Type, value *T) (id ID, err error) {
+	id = db.genNewId()
+	if id, err = db.writeItem(s, id, key, value, newSupersedeMarker(oldId, id, keyHash)); err != nil {
+		return 0, err
+	}
+	db.deleteById(s, oldId, keyHash)
+	return id, nil
 }
 
 // Marks item with a given Id for deletion, internal function, may be used for testing/benchmarking
-func (db *SimpleDb[T]) deleteById(id ID, keyHash hash.Type) error {
-	if db.readCache.contains(id) {
-		db.readCache.remove(id)
+// the shard must be locked
+func (db *SimpleDb[T]) deleteById(s *shard[T], id ID, keyHash hash.Type) error {
+	if s.readCache.contains(id) {
+		s.readCache.remove(id)
 	}
 
-	if !db.contains(id) { // should be in the file then
+	if !s.contains(id) { // should be in the file then
+		return &NotFoundError{id: id}
+	}
+	if _, ok := s.toBeDeleted[id]; ok { // already deleted, e.g. by a replayed marker
 		return &NotFoundError{id: id}
 	}
 
 	// else not yet in the file but in either of the two caches
 
-	db.toBeDeleted[id] = Flag{} // set map to empty value as a flag indicating the item is to be deleted
+	s.toBeDeleted[id] = Flag{} // set map to empty value as a flag indicating the item is to be deleted
+	if header, err := readBlockHeader(db.reader(), s.blockOffsets[id]); err == nil {
+		atomic.AddInt64(&db.deadBytes, int64(header.Length))
+	}
 	// remove the item from keyMap
 
 	// keyMap contains lists of item ids, which share the same keyHash value, due to hashing collisions
 	// to remove keyHash <-> id assignment, one has to find the right Id in the lice
-	idList := db.keyHashItems[keyHash]
+	idList := s.keyHashItems[keyHash]
 	for i, candidateId := range idList {
 		if candidateId == id {
 			idList[i] = idList[len(idList)-1]
-			db.keyHashItems[keyHash] = idList[:len(idList)-1]
+			s.keyHashItems[keyHash] = idList[:len(idList)-1]
 			// idList = append(idList[:i], idList[i+1:]...)
 			// db.keyHashItems[keyHash] = idList
 			break
 		}
 	}
 
-	db.ItemsCount--
+	atomic.AddInt64(&db.ItemsCount, -1)
 	return nil
 }
 
 // deletes a db item identified with the provided db key
 func (db *SimpleDb[T]) Delete(aKey string) (err error) {
-	db.mtx.Lock()
-	defer db.mtx.Unlock()
+	if db.cfg.readOnly {
+		return ErrReadOnly
+	}
+	keyHash := db.keyHash(aKey)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
 
-	keyHash := hash.Get(aKey)
-	ids, ok := db.keyHashItems[keyHash]
-	if !ok {
+	id, found, err := db.findItem(s, aKey, keyHash)
+	if err != nil {
+		return err
+	}
+	if !found {
 		return &NotFoundError{}
 	}
-	var key string
-	var id ID
-	for _, id = range ids {
-		key, _, err = db.getItem(id)
-		if err == nil && key == aKey {
-			db.deleteById(id, keyHash)
-			return nil
-		}
+	return db.removeItem(s, id, aKey, keyHash)
+}
+
+// writes a tombstone of the item, then drops it from the index, the shard must be locked
+func (db *SimpleDb[T]) removeItem(s *shard[T], id ID, key string, keyHash hash.Type) error {
+	if err := db.writeMarker(newMarker(blockTombstone, id, keyHash, nil)); err != nil {
+		return err
 	}
-	return &NotFoundError{id: id}
+	db.deleteById(s, id, keyHash)
+	db.keys.remove(key, id)
+	return nil
 }
 
 // closes the database and performs necessary housekeeping
 func (db *SimpleDb[T]) Close() (err error) {
+	db.compactMtx.Lock() // wait for a running compaction
+	defer db.compactMtx.Unlock()
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
-	var bytesWritten int64
-
-	var tmpFile = filepath.Join(DbPath, "temp.sdb")
+	var tmpFile = db.filePath + compactExt
 
+	db.stopBackground()
+	defer db.releaseLock(false) // after the file has been reorganized and the hint written
+	if err = db.syncAll(); err != nil {
+		return &DbInternalError{oper: "syncing", err: err}
+	}
 	if err = db.file.Close(); err != nil {
-		return &DbInternalError{oper: "closing: %w", err: err}
+		return &DbInternalError{oper: "closing", err: err}
+	}
+	if db.cfg.readOnly { // the file is left as it is
+		return nil
 	}
 
-	if len(db.toBeDeleted) != 0 { // if the database file needs to be reorganized
-		if bytesWritten, err = db.reorganizeDbFile(tmpFile); err != nil {
-			return &DbInternalError{oper: "reorganizing: %w", err: err}
+	if dropped := db.deletedItems(); len(dropped) != 0 { // if the database file needs to be reorganized
+		if err = db.removeHint(); err != nil { // the hint would not match the reorganized file
+			return &DbInternalError{oper: "removing hint file", err: err}
 		}
-		if bytesWritten == 0 { // if the db file is empty - i.e. everything has been deleted
-			if err := os.Remove(tmpFile); err != nil {
-				return &DbInternalError{oper: "removing tmp file: %w", err: err}
-			}
-			return nil
-		}
-
-		if err := os.Remove(db.filePath); err != nil { // switch the temp file with  the datbase file
-			return &DbInternalError{oper: "removing db file: %w", err: err}
+		blockOffsets, length, err := db.reorganizeDbFile(tmpFile, dropped)
+		if err != nil {
+			os.Remove(tmpFile)
+			return &DbInternalError{oper: "reorganizing", err: err}
 		}
 
+		// switch the temp file with the datbase file, rename replaces it atomically
 		if err := os.Rename(tmpFile, db.filePath); err != nil {
-			return &DbInternalError{oper: "renaming tmp to db file: %w", err: err}
+			return &DbInternalError{oper: "renaming tmp to db file", err: err}
+		}
+		if err := syncDir(filepath.Dir(db.filePath)); err != nil {
+			return &DbInternalError{oper: "syncing db dir", err: err}
 		}
+		db.relocate(blockOffsets, db.currentOffset, 0, dropped)
+		db.currentOffset = length
+		db.deadBytes = 0
 	}
 
+	if err = db.writeHint(); err != nil { // persist the index, so that the next Open does not have to scan the file
+		return &DbInternalError{oper: "writing hint file", err: err}
+	}
 	return
 }
 
-func (db *SimpleDb[T]) reorganizeDbFile(tmpFile string) (bytesWritten int64, err error) {
+// copies the database file to a temp file, while omitting deleted items and markers
+// returns offsets of the items in the new file and its length
+func (db *SimpleDb[T]) reorganizeDbFile(tmpFile string, dropped map[ID]Flag) (blockOffsets map[ID]int64, length int64, err error) {
 	var (
-		curpos int64
-		header blockHeader
-		src    *os.File
-		dest   *os.File
+		src  *os.File
+		dest *os.File
 	)
-	// copy the database file to a temp file, while omitting deleted items
 
-	if dest, err = openFile(tmpFile); err != nil {
-		return 0, err
+	if dest, err = os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, db.cfg.perm); err != nil {
+		return nil, 0, err
 	}
-	if src, err = openFile(db.filePath); err != nil {
-		return 0, err
+	if src, err = os.Open(db.filePath); err != nil {
+		dest.Close()
+		return nil, 0, err
 	}
 	defer func() {
 		src.Close()
-		dest.Close()
+		if cerr := dest.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
 	}()
 
-loop:
-	for {
-		if _, err = src.Seek(curpos, 0); err != nil {
-			return 0, err
-		}
-		if err = header.read(src); err != nil {
-			if errors.Is(err, io.EOF) {
-				err = nil
-				break loop
-			} else {
-				return 0, err
-			}
-		}
-		if _, delete := db.toBeDeleted[ID(header.Id)]; !delete {
-			buff := make([]byte, header.Length)
-			if _, err = src.Seek(curpos, 0); err != nil {
-				return 0, err
-			}
-			if _, err = src.Read(buff); err != nil {
-				return 0, err
-			}
-			if n, err := dest.Write(buff); err != nil {
-				return 0, err
-			} else {
-				bytesWritten += int64(n)
-			}
-		}
-		curpos += int64(header.Length)
+	if blockOffsets, length, err = copyLiveBlocks(context.Background(), src, dest, db.currentOffset, db.maxId, dropped, db.sealer); err != nil {
+		return nil, 0, err
 	}
-	return bytesWritten, err
+	return blockOffsets, length, dest.Sync() // the file must be durable before it replaces the db file
 }
 
 // generates new object id, now it's sequential, later maybe change to guid or what
 func (db *SimpleDb[T]) genNewId() (id ID) {
+	db.appendMtx.Lock()
+	defer db.appendMtx.Unlock()
 	id = ID(db.maxId)
 	db.maxId++
 
@@ -393,47 +674,167 @@ func (db *SimpleDb[T]) genNewId() (id ID) {
 }
 
 // rebuilds internal database structure: offsets map and key hash map
+// it's loaded from the hint file if there's a matching one, then only blocks appended after it was written are scanned
 func (db *SimpleDb[T]) loadDb() (err error) {
-	var (
-		curpos int64
-		lastId ID
-		count  int
-	)
+	keys := make(map[ID]string) // keys of the items, from the hint and the scanned blocks
+	curpos, err := db.loadHint(keys)
+	if err != nil { // no usable hint, scan the whole file
+		curpos = fileHeaderSize() // blocks start right after the file header
+		for _, s := range db.shards {
+			s.reset()
+		}
+		keys = make(map[ID]string)
+		db.ItemsCount = 0
+		db.maxId = 0 // value of the next ID to be generated
+		db.deadBytes = 0
+	}
+	if err = db.scanBlocks(curpos, keys); err != nil {
+		return err
+	}
+	return db.indexKeys(keys)
+}
 
-	db.blockOffsets = make(map[ID]int64)
-	var header blockHeader
+// scans the blocks from the given offset till the end of file, indexing the items and collecting their keys,
+// and replaying tombstone and supersede markers, so deleted and replaced items are not indexed
+func (db *SimpleDb[T]) scanBlocks(curpos int64, keys map[ID]string) (err error) {
+	superseded := make(map[ID]ID) // new item id -> id of the item it replaces
+
+	stat, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := stat.Size()
 
-loop:
 	for {
-		if _, err = db.file.Seek(int64(curpos), 0); err != nil {
+		block, err := readBlock(db.file, curpos) // read the whole block, verifying its checksum
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if isTornTail(db.file, curpos, fileSize, err) { // a write interrupted by a crash
+				if err = db.truncateTail(curpos, fileSize, err); err != nil {
+					return err
+				}
+				break
+			}
 			return err
 		}
-		if err = header.read(db.file); err != nil {
-			if errors.Is(err, io.EOF) {
-				break loop
-			} else {
+
+		if block.Type == blockBatch { // the blocks of a batch are replayed only if it's been committed
+			committed, err := db.batchCommitted(block, curpos, fileSize)
+			if err != nil {
 				return err
 			}
+			if !committed { // a write interrupted by a crash
+				if err = db.truncateTail(curpos, fileSize, errIncompleteBatch); err != nil {
+					return err
+				}
+				break
+			}
 		}
 
-		db.blockOffsets[ID(header.Id)] = curpos   // updat offsets map
-		db.keyHashItems[header.KeyHash] = append( // update kayhashmap
-			db.keyHashItems[header.KeyHash],
-			ID(header.Id))
-		curpos += int64(header.Length) // update current position in the file
-		if ID(header.Id) > lastId {    // keep track of the last id
-			lastId = ID(header.Id)
+		id, length := block.Id, int64(block.Length) // the length of an encrypted block changes when it's opened
+		s := db.shardOf(block.KeyHash)              // markers carry the key hash of the item they refer to
+		switch block.Type {
+		case blockItem:
+			if err = db.openBlock(block, curpos); err != nil {
+				return err
+			}
+			oldId, replaces := superseded[id]
+			if !replaces { // files upgraded from the legacy layout may hold several items with the same key
+				if err = db.dropDuplicate(s, block.KeyHash, block.key, keys); err != nil {
+					return err
+				}
+			}
+			db.indexItem(s, id, block.KeyHash, curpos) // update offsets map and key hash map
+			keys[id] = block.key
+			if replaces { // the marker is applied only if the new item made it to the file
+				db.deleteById(s, oldId, block.KeyHash)
+				delete(superseded, id)
+			}
+		case blockTombstone:
+			db.deleteById(s, id, block.KeyHash)
+			db.deadBytes += int64(block.Length)
+		case blockBatch, blockCommit:
+			db.deadBytes += int64(block.Length)
+		case blockMaxId: // not counted as dead, as compaction writes it again
+			if block.maxId() > db.maxId {
+				db.maxId = block.maxId()
+			}
+		case blockSupersede:
+			db.deadBytes += int64(block.Length)
+			superseded[block.supersededBy()] = id
+			if block.supersededBy() >= db.maxId { // the new id may not have made it to the file, but must not be reused
+				db.maxId = block.supersededBy() + 1
+			}
+		}
+		curpos += length    // update current position in the file
+		if id >= db.maxId { // keep track of the last id
+			db.maxId = id + 1
 		}
-		count++
 	}
 	db.currentOffset = curpos // update database parameters
-	db.ItemsCount = count
-	db.maxId = lastId + 1 // value of the next ID to be generated
 	return nil
 }
 
-// checks if the database contains an element with the given ID
-func (db *SimpleDb[T]) contains(id ID) (ok bool) {
-	_, ok = db.blockOffsets[id]
-	return
+// drops an already indexed item with the given key, so that the later one wins, as with Put
+func (db *SimpleDb[T]) dropDuplicate(s *shard[T], keyHash hash.Type, key string, keys map[ID]string) error {
+	for _, candidate := range s.keyHashItems[keyHash] {
+		other, ok := keys[candidate]
+		if !ok {
+			block, err := db.readItemBlock(s.blockOffsets[candidate])
+			if err != nil {
+				return err
+			}
+			other = block.key
+		}
+		if other == key {
+			db.deleteById(s, candidate, keyHash)
+			return nil
+		}
+	}
+	return nil
+}
+
+// builds the ordered key index, reading the keys of the live items missing from keys from the file
+func (db *SimpleDb[T]) indexKeys(keys map[ID]string) error {
+	for _, s := range db.shards {
+		for id, offset := range s.blockOffsets {
+			if _, deleted := s.toBeDeleted[id]; deleted {
+				continue
+			}
+			key, ok := keys[id]
+			if !ok {
+				block, err := db.readItemBlock(offset)
+				if err != nil {
+					return err
+				}
+				key = block.key
+			}
+			db.keys.put(key, id)
+		}
+	}
+	return nil
+}
+
+// stops background goroutines, may be called more than once
+func (db *SimpleDb[T]) stopBackground() {
+	select {
+	case <-db.closed:
+	default:
+		close(db.closed)
+	}
+}
+
+// calculates the key hash with the db hash function
+func (db *SimpleDb[T]) keyHash(key string) hash.Type {
+	return db.cfg.hashAlg.Get(key)
+}
+
+// returns the reader for blocks, either buffered or already in the file
+func (db *SimpleDb[T]) reader() io.ReaderAt {
+	if db.writer == nil { // while loading the db
+		return db.file
+	}
+	return db.writer
 }
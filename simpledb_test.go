@@ -1,15 +1,23 @@
 package simpledb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/kkonat/simpledb/hash"
+	"github.com/near/borsh-go"
 	log "github.com/sirupsen/logrus"
 )
 
 func TestNew(t *testing.T) {
-	d, err := Open[Person]("testdb", 1)
+	d, err := OpenWithCache[Person]("testdb", 1)
 	if err != nil {
 		t.Errorf("failed to create database: %v", err)
 	}
@@ -30,7 +38,7 @@ var testData []Person = []Person{
 
 func TestDestroy(t *testing.T) {
 	const CacheSize = 1
-	db, err := Open[Person]("testDestroy", CacheSize)
+	db, err := Open[Person]("testDestroy", WithCacheSize(CacheSize))
 	if err != nil {
 		t.Errorf("failed to create database: %v", err)
 	}
@@ -46,7 +54,7 @@ func TestAppendGetWithCache(t *testing.T) {
 	DeleteDbFile("testAppendGet")
 
 	// add item to db, then close (flush to disK)
-	db, err := Open[Person]("testAppendGet", CacheSize)
+	db, err := Open[Person]("testAppendGet", WithCacheSize(CacheSize))
 	if err != nil {
 		t.Errorf("failed to create database: %v", err)
 	}
@@ -54,7 +62,7 @@ func TestAppendGetWithCache(t *testing.T) {
 	db.Close()
 
 	// check if write cache flushed ok, item persisted
-	db, err = Open[Person]("testAppendGet", CacheSize)
+	db, err = Open[Person]("testAppendGet", WithCacheSize(CacheSize))
 	if err != nil {
 		t.Errorf("failed to reopen database: %v", err)
 	}
@@ -93,13 +101,20 @@ func TestAppendGetWithCache(t *testing.T) {
 		t.Error("data mismatch")
 	}
 }
+
+// simulates a crash: the file is closed as it is, and the lock is released, as it would be on exit
+func crash[T any](db *SimpleDb[T]) {
+	db.file.Close()
+	db.releaseLock(false)
+}
+
 func TestBasicFunctionality(t *testing.T) {
 	const CacheSize = 100
 
 	var err error
 	DeleteDbFile("testBasicFunx")
 
-	db1, err := Open[Person]("testBasicFunx", CacheSize)
+	db1, err := Open[Person]("testBasicFunx", WithCacheSize(CacheSize))
 	if err != nil {
 		t.Errorf("failed to create database: %v", err)
 	}
@@ -119,9 +134,9 @@ func TestBasicFunctionality(t *testing.T) {
 		t.Error("Bad id")
 	}
 	db1.Close()
-	db1, _ = Open[Person]("testBasicFunx", CacheSize)
-	// open another db from the same file
-	db2, err := Open[Person]("testBasicFunx", CacheSize)
+	db1, _ = Open[Person]("testBasicFunx", WithCacheSize(CacheSize), WithReadOnly())
+	// open another db from the same file, only readers may share it
+	db2, err := Open[Person]("testBasicFunx", WithCacheSize(CacheSize), WithReadOnly())
 	if err != nil {
 		t.Errorf("failed to open database: %v", err)
 	}
@@ -156,19 +171,19 @@ func TestBasicFunctionality(t *testing.T) {
 	}
 
 	// test chache
-	if hr := db2.readCache.GetHitRate(); hr != 0 {
+	if hr := db2.cacheHitRate(); hr != 0 {
 		log.Infof("Cache hit rate %.2f", hr)
 		t.Error("wrong hit rate")
 	}
 	_, _, _ = db2.getItem(id2)
 
-	if hr := db2.readCache.GetHitRate(); hr < 24.99 || hr > 25.01 {
+	if hr := db2.cacheHitRate(); hr < 24.99 || hr > 25.01 {
 		log.Infof("Cache hit rate %f", hr)
 		t.Error("wrong hit rate")
 	}
 	_, _, _ = db1.getItem(id2)
 	_, _, _ = db1.getItem(id2)
-	if hr := db1.readCache.GetHitRate(); hr < 49.99 || hr > 50.01 {
+	if hr := db1.cacheHitRate(); hr < 49.99 || hr > 50.01 {
 		log.Infof("Cache hit rate %f", hr)
 		t.Error("wrong hit rate")
 	}
@@ -179,13 +194,13 @@ func TestBasicFunctionality(t *testing.T) {
 func TestUpdate2(t *testing.T) {
 	const CacheSize = 100
 	DeleteDbFile("testUpdate2")
-	db, _ := Open[Person]("testUpdate2", CacheSize)
+	db, _ := Open[Person]("testUpdate2", WithCacheSize(CacheSize))
 	db.Append("Person1", &testData[0])
 	db.Append("Person2", &testData[1])
 	db.Append("Person3", &Person{Name: "Rudolfshien", Surname: "Von Der Shuster", Age: 4})
 	db.Close()
 	// test update
-	db, _ = Open[Person]("testUpdate2", CacheSize)
+	db, _ = Open[Person]("testUpdate2", WithCacheSize(CacheSize))
 	pers, _ := db.Get("Person2")
 	pers.Age = 1234
 	db.Update("Person2", pers)
@@ -198,7 +213,7 @@ func TestUpdate2(t *testing.T) {
 	db.Update("Person3", pers)
 	db.Close()
 
-	db, _ = Open[Person]("testUpdate2", CacheSize)
+	db, _ = Open[Person]("testUpdate2", WithCacheSize(CacheSize))
 
 	val, _ := db.Get("Person3")
 
@@ -208,6 +223,92 @@ func TestUpdate2(t *testing.T) {
 	db.Close()
 }
 
+func TestPutInsert(t *testing.T) {
+	DeleteDbFile("testPutInsert")
+	db, _ := Open[Person]("testPutInsert")
+	defer db.Destroy()
+
+	db.Put("Person1", &testData[0])
+	db.Put("Person1", &testData[1])
+	if val, err := db.Get("Person1"); err != nil || *val != testData[1] {
+		t.Error("put should replace the value", val, err)
+	}
+	if db.ItemsCount != 1 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	if _, err := db.Insert("Person1", &testData[2]); !errors.Is(err, ErrKeyExists) {
+		t.Error("insert of an existing key should fail, got: ", err)
+	}
+	if _, err := db.Insert("Person2", &testData[2]); err != nil {
+		t.Error("insert of a new key failed: ", err)
+	}
+	var notFound *NotFoundError
+	if _, err := db.Update("Person3", &testData[0]); !errors.As(err, &notFound) {
+		t.Error("update of a missing key should fail, got: ", err)
+	}
+	db.Append("Person2", &testData[0]) // Append is an alias of Put
+	if db.ItemsCount != 2 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+}
+
+func TestHasLen(t *testing.T) {
+	DeleteDbFile("testHas")
+	db, _ := Open[Person]("testHas", WithCacheSize(0))
+	defer db.Destroy()
+	db.Put("Person1", &testData[0])
+	db.Put("Person2", &testData[1])
+	db.Delete("Person2")
+	db.Flush()
+
+	// damage the value, Has does not read it
+	offset := db.shardOf(db.keyHash("Person1")).blockOffsets[0]
+	header, _ := readBlockHeader(db.file, offset)
+	f, _ := os.OpenFile(db.filePath, os.O_RDWR, 0600)
+	f.WriteAt([]byte{0xff}, offset+int64(header.Length)-1)
+	f.Close()
+
+	if ok, err := db.Has("Person1"); !ok || err != nil {
+		t.Error("key should exist", err)
+	}
+	for _, key := range []string{"Person2", "Person3"} {
+		if ok, err := db.Has(key); ok || err != nil {
+			t.Error("key should not exist: ", key, err)
+		}
+	}
+	if _, err := db.Get("Person1"); !isCorrupt(err) {
+		t.Error("expected corrupt block error, got: ", err)
+	}
+	if db.Len() != 1 {
+		t.Error("wrong length: ", db.Len())
+	}
+}
+
+func TestDuplicateKeysOnLoad(t *testing.T) {
+	DeleteDbFile("testDuplicates")
+	db, _ := Open[Person]("testDuplicates")
+	keyHash := db.keyHash("Person1")
+	s := db.lockShard(keyHash) // append the same key twice, as files upgraded from the legacy layout may have it
+	db.appendItem(s, "Person1", &testData[0])
+	db.appendItem(s, "Person1", &testData[1])
+	s.mtx.Unlock()
+	db.mtx.RUnlock()
+	db.Flush()
+	crash(db) // crash, the db is not reorganized
+
+	db, err := Open[Person]("testDuplicates")
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Destroy()
+	if db.ItemsCount != 1 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	if val, err := db.Get("Person1"); err != nil || *val != testData[1] {
+		t.Error("the later item should win", val, err)
+	}
+}
+
 func TestCache(t *testing.T) {
 	var (
 		d   *benchmarkData
@@ -215,7 +316,7 @@ func TestCache(t *testing.T) {
 	)
 	const CacheSize = 1000
 	DeleteDbFile("benchmarkCache")
-	db, _ := Open[benchmarkData]("benchmarkCache", CacheSize)
+	db, _ := Open[benchmarkData]("benchmarkCache", WithCacheSize(CacheSize))
 
 	// gen 2 x times the cache capacity
 	// so the expected hitrate is 50%
@@ -231,12 +332,14 @@ func TestCache(t *testing.T) {
 	}
 	db.Close()
 
-	db, _ = Open[benchmarkData]("benchmarkCache", CacheSize)
+	db, _ = Open[benchmarkData]("benchmarkCache", WithCacheSize(CacheSize))
 	// fill cache
 	for n := 0; n < CacheSize; n++ {
 		db.getItem(ID(n))
 	}
-	db.readCache.statistics.requests = 0 // artificially reset no. of requests
+	for _, s := range db.shards {
+		s.readCache.statistics.requests = 0 // artificially reset no. of requests
+	}
 	// test hits
 	for n := 0; n < numElements; n++ {
 
@@ -247,7 +350,7 @@ func TestCache(t *testing.T) {
 			t.Errorf("values dont match: %s vs %s", d.Str, reference[ID(n)])
 		}
 	}
-	hr := db.readCache.GetHitRate()
+	hr := db.cacheHitRate()
 	if hr < expectedHitrate-5 || hr > expectedHitrate+5 {
 		t.Error("Wrong cache Hit rate: ", hr, "%, expected:", expectedHitrate, "%")
 	}
@@ -265,7 +368,7 @@ func TestDeleteAndUpdate(t *testing.T) {
 
 	DeleteDbFile("delLogic")
 
-	db, _ := Open[benchmarkData]("delLogic", CacheSize)
+	db, _ := Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 
 	// add
 	for n := 0; n < N; n++ {
@@ -277,7 +380,7 @@ func TestDeleteAndUpdate(t *testing.T) {
 	db.Close()
 
 	// modify half
-	db, _ = Open[benchmarkData]("delLogic", CacheSize)
+	db, _ = Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 	for n := N / 2; n < N; n++ {
 		x := rand.Intn(N)
 		key := fmt.Sprintf("Item%d", x)
@@ -295,7 +398,7 @@ func TestDeleteAndUpdate(t *testing.T) {
 	db.Close()
 
 	// delete randomly
-	db, _ = Open[benchmarkData]("delLogic", CacheSize)
+	db, _ = Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 	log.Info("db size", db.ItemsCount)
 	for n := 0; n < N; n++ {
 		which := rand.Intn(len(elements))
@@ -316,13 +419,411 @@ func TestDeleteAndUpdate(t *testing.T) {
 		t.Error("should not be able to delete")
 	}
 
-	l := db.readCache.queue.Len()
+	l := db.cachedItems()
 	if l != 0 {
 		t.Error("cache should be empty, but is :", l)
 	}
 	if err = db.Close(); err != nil {
 		t.Error("error closing db :", err)
 	}
-	log.Info("Cache Hit rate: ", db.readCache.GetHitRate(), " %")
+	log.Info("Cache Hit rate: ", db.cacheHitRate(), " %")
+	db.Close()
+}
+
+func TestDeleteUpdatePersistWithoutClose(t *testing.T) {
+	const CacheSize = 10
+	DeleteDbFile("testNoClose")
+
+	db, _ := Open[Person]("testNoClose", WithCacheSize(CacheSize))
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1])
+	db.Append("Person3", &testData[2])
+	db.Delete("Person1")
+	db.Update("Person2", &Person{Name: "Updated", Age: 1})
+	crash(db) // simulate a crash, Close does not get to reorganize the file
+
+	db, err := Open[Person]("testNoClose", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if _, err = db.Get("Person1"); err == nil {
+		t.Error("deleted item should not come back")
+	}
+	if val, err := db.Get("Person2"); err != nil || val.Name != "Updated" {
+		t.Error("updated item should have the new value")
+	}
+	if len(db.shardOf(hash.Get("Person2")).keyHashItems[hash.Get("Person2")]) != 1 {
+		t.Error("updated item should have a single live version")
+	}
+	if db.ItemsCount != 2 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	db.Close()
+
+	db, _ = Open[Person]("testNoClose", WithCacheSize(CacheSize))
+	if val, err := db.Get("Person3"); err != nil || *val != testData[2] {
+		t.Error("item lost on reorganization")
+	}
+	if db.ItemsCount != 2 {
+		t.Error("wrong items count after reorganization: ", db.ItemsCount)
+	}
 	db.Close()
 }
+
+func TestCorruptBlock(t *testing.T) {
+	const CacheSize = 1
+	DeleteDbFile("testCorrupt")
+
+	db, _ := Open[Person]("testCorrupt", WithCacheSize(CacheSize), WithShards(1)) // a single cache, so that Person2 evicts Person1
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1]) // evicts Person1 from the cache
+
+	// flip a bit in the last byte of the first block's value
+	offset := db.shards[0].blockOffsets[0]
+	buff := make([]byte, blockheadersSize())
+	db.file.ReadAt(buff, offset)
+	length := int64(binary.LittleEndian.Uint32(buff))
+	f, _ := os.OpenFile(db.filePath, os.O_RDWR, 0600)
+	f.WriteAt([]byte{0xff}, offset+length-1)
+	f.Close()
+
+	var corrupt *CorruptBlockError
+	_, err := db.Get("Person1")
+	if !errors.As(err, &corrupt) || corrupt.Id != 0 || corrupt.Offset != offset {
+		t.Error("expected corrupt block error, got: ", err)
+	}
+	crash(db)
+
+	_, err = Open[Person]("testCorrupt", WithCacheSize(CacheSize))
+	if !errors.As(err, &corrupt) {
+		t.Error("expected open to fail with corrupt block error, got: ", err)
+	}
+	DeleteDbFile("testCorrupt")
+}
+
+func TestTornWriteRecovery(t *testing.T) {
+	const CacheSize = 10
+	DeleteDbFile("testTorn")
+
+	db, _ := Open[Person]("testTorn", WithCacheSize(CacheSize))
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1])
+	goodSize := db.currentOffset
+	if db.Recovery() != nil {
+		t.Error("fresh database should not report recovery")
+	}
+	crash(db)
+
+	// simulate a crash in the middle of writing the third block
+	f, _ := os.OpenFile(db.filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	data := NewBlock(2, "Person3", []byte("some value")).getBytes()
+	f.Write(data[:len(data)-3])
+	f.Close()
+
+	db, err := Open[Person]("testTorn", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	report := db.Recovery()
+	if report == nil || report.TruncatedAt != goodSize || report.DiscardedBytes != int64(len(data)-3) {
+		t.Errorf("wrong recovery report: %+v", report)
+	}
+	if stat, _ := os.Stat(db.filePath); stat.Size() != goodSize {
+		t.Error("file not truncated to the last good block")
+	}
+	if db.ItemsCount != 2 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	db.Append("Person3", &testData[2])
+	crash(db)
+
+	// a tail of zeroed pages is also discarded
+	f, _ = os.OpenFile(db.filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	f.Write(make([]byte, 4096))
+	f.Close()
+
+	db, err = Open[Person]("testTorn", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if db.Recovery() == nil {
+		t.Error("zeroed tail should be reported")
+	}
+	if val, err := db.Get("Person3"); err != nil || *val != testData[2] {
+		t.Error("item appended after recovery lost")
+	}
+	db.Destroy()
+}
+
+func TestZeroTail(t *testing.T) {
+	tail := make([]byte, 3*tailChunkSize+100)
+	if !zerosTill(bytes.NewReader(tail), 10, int64(len(tail))) {
+		t.Error("zeroed tail should be detected")
+	}
+	tail[len(tail)-1] = 1
+	if zerosTill(bytes.NewReader(tail), 10, int64(len(tail))) {
+		t.Error("non-zero byte past the first chunk should be found")
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	const CacheSize = 1
+	DeleteDbFile("testHeader")
+
+	db, _ := Open[Person]("testHeader", WithCacheSize(CacheSize))
+	db.Append("Person1", &testData[0])
+	db.Close()
+
+	// file written with crc32 must not be opened with another hash function
+	hash.SetAlgorithm(hash.Superfast)
+	_, err := Open[Person]("testHeader", WithCacheSize(CacheSize))
+	hash.SetAlgorithm(hash.CRC32)
+	var headerErr *FileHeaderError
+	if !errors.As(err, &headerErr) || headerErr.Field != "hash algorithm" {
+		t.Error("expected hash algorithm mismatch, got: ", err)
+	}
+
+	db, err = Open[Person]("testHeader", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to get item")
+	}
+	db.Destroy()
+
+	// not a database file
+	os.WriteFile(getFilepath("testHeader", DbExt), []byte("definitely not a database file"), 0600)
+	if _, err = Open[Person]("testHeader", WithCacheSize(CacheSize)); !errors.As(err, &headerErr) || headerErr.Field != "magic number" {
+		t.Error("expected magic number mismatch, got: ", err)
+	}
+	DeleteDbFile("testHeader")
+}
+
+// writes an item in the legacy layout, with no file header, markers nor checksums
+func writeLegacyItem(buf *bytes.Buffer, id ID, key string, value *Person) {
+	data, _ := borsh.Serialize(value)
+	header := legacyBlockHeader{Id: id, KeyHash: hash.Get(key), KeyLen: uint32(len(key)), DataLen: uint32(len(data))}
+	header.Length = uint32(legacyHeaderSize()) + header.KeyLen + header.DataLen
+	binary.Write(buf, binary.LittleEndian, &header)
+	buf.WriteString(key)
+	buf.Write(data)
+}
+
+func TestLegacyUpgrade(t *testing.T) {
+	DeleteDbFile("testLegacy")
+	var buf bytes.Buffer
+	writeLegacyItem(&buf, 0, "Person0", &testData[0])
+	writeLegacyItem(&buf, 1, "Person1", &testData[1])
+	writeLegacyItem(&buf, 2, "Person0", &testData[2]) // the legacy layout allowed duplicate keys
+	os.MkdirAll(filepath.Dir(getFilepath("testLegacy", DbExt)), 0700)
+	os.WriteFile(getFilepath("testLegacy", DbExt), buf.Bytes(), 0600)
+
+	if _, err := Open[Person]("testLegacy", WithReadOnly()); !errors.Is(err, ErrLegacyFile) {
+		t.Error("expected legacy file error in read-only mode, got: ", err)
+	}
+	if _, err := Open[Person]("testLegacy", WithCodec[Person](JSONCodec[Person]{})); err == nil {
+		t.Error("legacy values are borsh, opening with another codec should fail")
+	}
+
+	db, err := Open[Person]("testLegacy")
+	if err != nil {
+		t.Fatalf("failed to upgrade database: %v", err)
+	}
+	check := func(n int) {
+		if db.Len() != n {
+			t.Error("wrong length: ", db.Len())
+		}
+		if val, err := db.Get("Person0"); err != nil || *val != testData[2] {
+			t.Error("the later item should win", val, err)
+		}
+		if val, err := db.Get("Person1"); err != nil || *val != testData[1] {
+			t.Error("failed to get item", val, err)
+		}
+	}
+	check(2)
+	db.Put("Person2", &testData[0]) // appended in the current layout
+	db.Close()
+
+	if db, err = Open[Person]("testLegacy"); err != nil {
+		t.Fatalf("failed to reopen upgraded database: %v", err)
+	}
+	defer db.Destroy()
+	check(3)
+	if _, err := os.Stat(getFilepath("testLegacy", DbExt) + upgradeExt); !os.IsNotExist(err) {
+		t.Error("upgrade file should be gone: ", err)
+	}
+}
+
+// loads the hint file of the db, without modifying the db index
+func hintOf[T any](db *SimpleDb[T]) (int64, error) {
+	probe := &SimpleDb[T]{filePath: db.filePath, file: db.file, shards: newShards[T](len(db.shards), 0), sealer: db.sealer}
+	return probe.loadHint(make(map[ID]string))
+}
+
+func TestHintFile(t *testing.T) {
+	const CacheSize = 10
+	DeleteDbFile("testHint")
+
+	db, _ := Open[Person]("testHint", WithCacheSize(CacheSize))
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1])
+	db.Append("Person3", &testData[2])
+	db.Delete("Person2")
+	db.Close()
+
+	db, _ = Open[Person]("testHint", WithCacheSize(CacheSize))
+	if length, err := hintOf(db); err != nil || length != db.currentOffset {
+		t.Error("hint should match the data file: ", err)
+	}
+	db.Append("Person4", &testData[0])
+	crash(db) // crash, the hint is not updated
+
+	db, err := Open[Person]("testHint", WithCacheSize(CacheSize))
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if _, err = hintOf(db); err != nil {
+		t.Error("hint should still be usable for the head of the file: ", err)
+	}
+	db.Close()
+
+	db, _ = Open[Person]("testHint", WithCacheSize(CacheSize))
+	if db.ItemsCount != 3 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	for _, key := range []string{"Person1", "Person3", "Person4"} {
+		if _, err := db.Get(key); err != nil {
+			t.Error("failed to get ", key, err)
+		}
+	}
+	if _, err := db.Get("Person2"); err == nil {
+		t.Error("deleted item should not come back")
+	}
+	crash(db)
+
+	// the hint does not match a data file with different contents
+	os.Truncate(db.filePath, fileHeaderSize())
+	db, _ = Open[Person]("testHint", WithCacheSize(CacheSize))
+	if _, err := hintOf(db); err == nil {
+		t.Error("stale hint should be rejected")
+	}
+	if db.ItemsCount != 0 {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	db.Destroy()
+}
+
+func TestHintKeys(t *testing.T) {
+	const N = 100
+	DeleteDbFile("testHintKeys")
+	db, _ := Open[Person]("testHintKeys")
+	for i := 0; i < N; i++ {
+		db.Put(fmt.Sprintf("Person%03d", i), &Person{Name: "name", Age: uint(i)})
+	}
+	db.Delete("Person010")
+	db.Close()
+
+	db, _ = Open[Person]("testHintKeys")
+	corrupted := fmt.Sprintf("Person%03d", N/2)
+	id, _, _ := db.findItem(db.shardOf(db.keyHash(corrupted)), corrupted, db.keyHash(corrupted))
+	offset := db.shardOfId(id).blockOffsets[id]
+	header, _ := readBlockHeader(db.file, offset)
+	db.Close()
+
+	// a damaged value in the middle of the file is not read on open, as the keys come from the hint
+	f, _ := os.OpenFile(db.filePath, os.O_WRONLY, 0600)
+	f.WriteAt([]byte{0xff}, offset+int64(header.Length)-1)
+	f.Close()
+	db, err := Open[Person]("testHintKeys")
+	if err != nil {
+		t.Fatalf("open with a valid hint should not read the items: %v", err)
+	}
+	if _, err := db.Get(corrupted); !isCorrupt(err) {
+		t.Error("expected corrupt block error, got: ", err)
+	}
+	db.Put("Person999", &testData[0]) // after the hint
+	crash(db)
+
+	db, _ = Open[Person]("testHintKeys")
+	defer db.Destroy()
+	keys, _ := db.keys.keys("", "", false, nil, 0)
+	if len(keys) != N || keys[0] != "Person000" || keys[10] != "Person011" || keys[N-1] != "Person999" {
+		t.Error("wrong keys in the index: ", len(keys), keys)
+	}
+	if items, _, err := db.Prefix("Person09"); err != nil || len(items) != 10 {
+		t.Error("wrong prefix scan: ", len(items), err)
+	}
+}
+
+func TestDirOptions(t *testing.T) {
+	const CacheSize = 1
+	dirs := []string{t.TempDir(), filepath.Join(t.TempDir(), "nested", "dir")}
+
+	// databases with the same name, in different dirs, side by side
+	dbs := make([]*SimpleDb[Person], len(dirs))
+	for i, dir := range dirs {
+		db, err := Open[Person]("people", WithCacheSize(CacheSize), WithDir(dir), WithExt(".db"), WithFileMode(0640))
+		if err != nil {
+			t.Fatalf("failed to create database: %v", err)
+		}
+		if db.filePath != filepath.Join(dir, "people.db") {
+			t.Error("wrong db file path: ", db.filePath)
+		}
+		db.Append("Person1", &testData[i])
+		dbs[i] = db
+	}
+	for i, db := range dbs {
+		if val, err := db.Get("Person1"); err != nil || *val != testData[i] {
+			t.Error("wrong value", err)
+		}
+		db.Close()
+		if stat, err := os.Stat(db.filePath); err != nil || stat.Mode().Perm() != 0640 {
+			t.Error("wrong db file permissions")
+		}
+	}
+	if err := DeleteDbFile("people", WithDir(dirs[0]), WithExt(".db")); err != nil {
+		t.Error("failed to delete db file", err)
+	}
+
+	if _, err := Open[Person]("people", WithCacheSize(CacheSize), WithFileMode(0444)); err == nil {
+		t.Error("read-only file mode should be rejected")
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	const N = 200
+	DeleteDbFile("testConcurrentGet")
+	db, _ := Open[Person]("testConcurrentGet", WithCacheSize(N/4))
+	defer db.Destroy()
+	for i := 0; i < N; i++ {
+		db.Append(fmt.Sprint("Person", i), &Person{Name: fmt.Sprint("Name", i), Age: uint(i)})
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 500; n++ {
+				i := (g*31 + n*7) % N
+				val, err := db.Get(fmt.Sprint("Person", i))
+				if err != nil || val.Name != fmt.Sprint("Name", i) || val.Age != uint(i) {
+					t.Errorf("item %d: got %v, %v", i, val, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() { // a writer keeps appending meanwhile
+		defer wg.Done()
+		for i := N; i < N+100; i++ {
+			db.Append(fmt.Sprint("Person", i), &Person{Name: fmt.Sprint("Name", i), Age: uint(i)})
+		}
+	}()
+	wg.Wait()
+	if l := db.cachedItems(); l > N/4+len(db.shards) { // each shard may overshoot by an item
+		t.Errorf("cache holds %d items, more than its size", l)
+	}
+}
@@ -27,7 +27,7 @@ func BenchmarkPerfWithCache(b *testing.B) {
 	var numElements = uint32(b.N)
 
 	DeleteDbFile("benchmark")
-	db, _ := Open[benchmarkData]("benchmark", CacheSize)
+	db, _ := Open[benchmarkData]("benchmark", WithCacheSize(CacheSize))
 
 	reference := make(map[ID]string)
 	for n := 0; n < b.N; n++ {
@@ -37,7 +37,7 @@ func BenchmarkPerfWithCache(b *testing.B) {
 	}
 	// db.Close()
 
-	// db, _ = Open[benchmarkData]("benchmark", CacheSize)
+	// db, _ = Open[benchmarkData]("benchmark", WithCacheSize(CacheSize))
 	for n := 0; n < b.N; n++ {
 		rndNo := ID(rand.Intn(int(numElements)))
 		if _, _, err = db.getItem(rndNo); err != nil {
@@ -45,7 +45,54 @@ func BenchmarkPerfWithCache(b *testing.B) {
 		}
 	}
 	db.Close()
-	log.Info("-> ", b.N, " iterations. Cache Hit rate: ", db.readCache.GetHitRate(), " %")
+	log.Info("-> ", b.N, " iterations. Cache Hit rate: ", db.cacheHitRate(), " %")
+}
+
+// concurrent readers, run with -cpu 1,2,4,8 to see how Get scales with cores
+func BenchmarkGetParallel(b *testing.B) {
+	const N = 10000
+	DeleteDbFile("benchmarkParallel")
+	db, _ := Open[benchmarkData]("benchmarkParallel", WithCacheSize(N/10))
+	for n := 0; n < N; n++ {
+		db.Append(fmt.Sprintf("Item%d", n), NewBenchmarkData(n))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			if _, err := db.Get(fmt.Sprintf("Item%d", r.Intn(N))); err != nil {
+				b.Error("get failed", err)
+			}
+		}
+	})
+	b.StopTimer()
+	db.Destroy()
+}
+
+// compares parallel writers of a single shard with the default number of shards
+func BenchmarkUpdateParallel(b *testing.B) {
+	const N = 10000
+	for _, shards := range []int{1, defaultShards} {
+		b.Run(fmt.Sprint("shards=", shards), func(b *testing.B) {
+			DeleteDbFile("benchmarkParallelUpdate")
+			db, _ := Open[benchmarkData]("benchmarkParallelUpdate", WithCacheSize(N/10), WithShards(shards))
+			for n := 0; n < N; n++ {
+				db.Append(fmt.Sprintf("Item%d", n), NewBenchmarkData(n))
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					n := r.Intn(N)
+					if _, err := db.Update(fmt.Sprintf("Item%d", n), NewBenchmarkData(n)); err != nil {
+						b.Error("update failed", err)
+					}
+				}
+			})
+			b.StopTimer()
+			db.Destroy()
+		})
+	}
 }
 
 func BenchmarkDeleteAndUpdate(b *testing.B) {
@@ -62,7 +109,7 @@ func BenchmarkDeleteAndUpdate(b *testing.B) {
 
 	DeleteDbFile("delLogic")
 
-	db, _ := Open[benchmarkData]("delLogic", CacheSize)
+	db, _ := Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 
 	// add
 
@@ -76,7 +123,7 @@ func BenchmarkDeleteAndUpdate(b *testing.B) {
 	db.Close()
 
 	// modify half
-	db, _ = Open[benchmarkData]("delLogic", CacheSize)
+	db, _ = Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 	for n := 0; n < N/2; n++ {
 		x := rand.Intn(N)
 		key := fmt.Sprintf("Item%d", x)
@@ -99,7 +146,7 @@ func BenchmarkDeleteAndUpdate(b *testing.B) {
 	db.Close()
 
 	// delete randomly
-	db, _ = Open[benchmarkData]("delLogic", CacheSize)
+	db, _ = Open[benchmarkData]("delLogic", WithCacheSize(CacheSize))
 	for n := 0; n < N; n++ {
 		which := rand.Intn(len(elements))
 		elNo := elements[which]
@@ -0,0 +1,171 @@
+package simpledb
+
+import (
+	"math/rand"
+	"sync"
+)
+
+const skiplistMaxLevel = 24 // enough for 2^24 keys with p = 1/2, more keys only make the search a bit longer
+
+type skipNode struct {
+	key  string
+	id   ID
+	prev *skipNode   // previous node on the bottom level, for reverse iteration, nil for the first node
+	next []*skipNode // next nodes on each level the node is on
+}
+
+// ordered index of the keys, maps each key to the id of its live item
+// safe for concurrent use, writers of different shards update it
+type skiplist struct {
+	mtx   sync.RWMutex
+	head  *skipNode // sentinel, its next nodes start each level
+	level int       // number of levels in use
+	len   int
+	rnd   *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skipNode{next: make([]*skipNode, skiplistMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// finds the last node with a key less than the given one on each level, must be called with the lock held
+func (l *skiplist) predecessors(key string) (preds [skiplistMaxLevel]*skipNode) {
+	node := l.head
+	for lvl := l.level - 1; lvl >= 0; lvl-- {
+		for node.next[lvl] != nil && node.next[lvl].key < key {
+			node = node.next[lvl]
+		}
+		preds[lvl] = node
+	}
+	return preds
+}
+
+func (l *skiplist) randomLevel() int {
+	lvl := 1
+	for lvl < skiplistMaxLevel && l.rnd.Int63()&1 == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// adds the key, or sets the id of the item if it's already in the index
+func (l *skiplist) put(key string, id ID) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	preds := l.predecessors(key)
+	if node := preds[0].next[0]; node != nil && node.key == key {
+		node.id = id
+		return
+	}
+	lvl := l.randomLevel()
+	for ; l.level < lvl; l.level++ {
+		preds[l.level] = l.head
+	}
+	node := &skipNode{key: key, id: id, next: make([]*skipNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		node.next[i] = preds[i].next[i]
+		preds[i].next[i] = node
+	}
+	if preds[0] != l.head {
+		node.prev = preds[0]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node
+	}
+	l.len++
+}
+
+// removes the key, if it still belongs to the item with the given id
+func (l *skiplist) remove(key string, id ID) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	preds := l.predecessors(key)
+	node := preds[0].next[0]
+	if node == nil || node.key != key || node.id != id {
+		return
+	}
+	for i := range node.next {
+		preds[i].next[i] = node.next[i]
+	}
+	if node.next[0] != nil {
+		node.next[0].prev = node.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.len--
+}
+
+// returns the first node with a key greater or equal to the given one, must be called with the lock held
+func (l *skiplist) seek(key string) *skipNode {
+	return l.predecessors(key)[0].next[0]
+}
+
+// returns the last node with a key less than the given one, or the last node if unbounded, must be called with the lock held
+func (l *skiplist) seekBefore(key string, unbounded bool) *skipNode {
+	node := l.head
+	for lvl := l.level - 1; lvl >= 0; lvl-- {
+		for node.next[lvl] != nil && (unbounded || node.next[lvl].key < key) {
+			node = node.next[lvl]
+		}
+	}
+	if node == l.head {
+		return nil
+	}
+	return node
+}
+
+// returns up to limit keys in the range [start, end), an empty end meaning no upper bound,
+// in key order or in reverse, skipping the keys up to and including after, if it's set
+// more tells if there are further keys in the range
+func (l *skiplist) keys(start, end string, reverse bool, after *string, limit int) (keys []string, more bool) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	inRange := func(n *skipNode) bool {
+		return n != nil && n.key >= start && (end == "" || n.key < end)
+	}
+	var node *skipNode
+	switch {
+	case !reverse && after != nil && *after >= start:
+		if node = l.seek(*after); node != nil && node.key == *after {
+			node = node.next[0]
+		}
+	case !reverse:
+		node = l.seek(start)
+	case after != nil && (end == "" || *after < end):
+		node = l.seekBefore(*after, false)
+	default:
+		node = l.seekBefore(end, end == "")
+	}
+	for ; inRange(node); node = l.step(node, reverse) {
+		if limit > 0 && len(keys) == limit {
+			return keys, true
+		}
+		keys = append(keys, node.key)
+	}
+	return keys, false
+}
+
+func (l *skiplist) step(node *skipNode, reverse bool) *skipNode {
+	if reverse {
+		return node.prev
+	}
+	return node.next[0]
+}
+
+// calls fn for each key and the id of its item, in key order
+func (l *skiplist) each(fn func(key string, id ID)) {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	for node := l.head.next[0]; node != nil; node = node.next[0] {
+		fn(node.key, node.id)
+	}
+}
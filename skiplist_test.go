@@ -0,0 +1,52 @@
+package simpledb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	const N = 1000
+	l := newSkiplist()
+	reference := make(map[string]ID)
+	for i := 0; i < N; i++ {
+		key := fmt.Sprint("key", rand.Intn(N))
+		l.put(key, ID(i))
+		reference[key] = ID(i)
+	}
+	for key, id := range reference {
+		if rand.Intn(2) == 0 {
+			l.remove(key, id+1) // stale id, ignored
+			l.remove(key, id)
+			delete(reference, key)
+		}
+	}
+	sorted := make([]string, 0, len(reference))
+	for key := range reference {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	keys, more := l.keys("", "", false, nil, 0)
+	if more || fmt.Sprint(keys) != fmt.Sprint(sorted) || l.len != len(sorted) {
+		t.Fatal("keys should be sorted")
+	}
+	keys, _ = l.keys("", "", true, nil, 0)
+	for i, key := range keys {
+		if key != sorted[len(sorted)-1-i] {
+			t.Fatal("keys should be in reverse order")
+		}
+	}
+	q := len(sorted) / 4
+	start, end := sorted[q], sorted[len(sorted)/2]
+	keys, more = l.keys(start, end, false, nil, 10)
+	if !more || len(keys) != 10 || keys[0] != start {
+		t.Error("wrong range: ", keys)
+	}
+	keys, _ = l.keys(start, end, true, &keys[5], 3)
+	if fmt.Sprint(keys) != fmt.Sprint([]string{sorted[q+4], sorted[q+3], sorted[q+2]}) {
+		t.Error("reverse range should resume before the cursor: ", keys)
+	}
+}
@@ -0,0 +1,179 @@
+package simpledb
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errDestroyed = errors.New("database destroyed")
+
+// determines when writes are made durable with fsync
+type SyncMode int
+
+const (
+	SyncNever  SyncMode = iota // the file is never synced, left to the OS, except on Sync() and Close
+	SyncAlways                 // every write is flushed and synced before the call returns
+	SyncGroup                  // writes are synced in groups every interval, callers block until their write is durable
+)
+
+// lets concurrent writers share a single fsync
+// writes are numbered, the sequence number of the last write is kept under db.appendMtx
+type groupSyncer struct {
+	written uint64 // sequence number of the last write, guarded by db.appendMtx
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	synced  uint64 // sequence number of the last write known to be durable
+	err     error  // error of the last failed sync
+	errFrom uint64 // writes in the range (errFrom, errTo] failed to sync
+	errTo   uint64
+	syncs   int // number of syncs performed
+}
+
+func newGroupSyncer() *groupSyncer {
+	s := &groupSyncer{}
+	s.cond = sync.NewCond(&s.mtx)
+	return s
+}
+
+// marks writes up to target as synced, or failed to sync, and wakes up the waiting writers
+func (s *groupSyncer) done(target uint64, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if target <= s.synced {
+		return
+	}
+	if err != nil {
+		s.err, s.errFrom, s.errTo = err, s.synced, target
+	}
+	s.synced = target
+	s.syncs++
+	s.cond.Broadcast()
+}
+
+// blocks until the write with the given sequence number is durable
+func (s *groupSyncer) wait(seq uint64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for s.synced < seq {
+		s.cond.Wait()
+	}
+	if s.err != nil && seq > s.errFrom && seq <= s.errTo {
+		return &DbInternalError{oper: "syncing", err: s.err}
+	}
+	return nil
+}
+
+// called after each write, makes it durable in SyncAlways mode, writes are counted by appendBlocks for SyncGroup
+func (db *SimpleDb[T]) synchronize() error {
+	if db.cfg.syncMode != SyncAlways {
+		return nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return err
+	}
+	return db.file.Sync()
+}
+
+// unlocks the shards and the db after a write and, in group commit mode, waits until the write is durable
+// to be deferred by the public methods writing to the file
+// it also triggers automatic compaction, if it's due
+func (db *SimpleDb[T]) unlockDurable(err *error, shards ...*shard[T]) {
+	if *err == nil {
+		db.maybeCompact()
+	}
+	for _, s := range shards {
+		s.mtx.Unlock()
+	}
+	if db.syncer == nil || *err != nil {
+		db.mtx.RUnlock()
+		return
+	}
+	db.appendMtx.Lock()
+	seq := db.syncer.written // the last write, which is ours or a later one
+	db.appendMtx.Unlock()
+	db.mtx.RUnlock()
+	*err = db.syncer.wait(seq)
+}
+
+// flushes and syncs all the writes so far, must be called with db.mtx locked or read-locked
+func (db *SimpleDb[T]) syncAll() (err error) {
+	db.appendMtx.Lock()
+	err = db.writer.flush()
+	var target uint64
+	if db.syncer != nil {
+		target = db.syncer.written
+	}
+	db.appendMtx.Unlock()
+
+	if err == nil {
+		err = db.file.Sync()
+	}
+	if db.syncer != nil {
+		db.syncer.done(target, err)
+	}
+	return err
+}
+
+// wakes up the writers waiting for a sync with an error, their writes go away with the destroyed file
+func (db *SimpleDb[T]) abortSyncs() {
+	if db.syncer == nil {
+		return
+	}
+	db.appendMtx.Lock()
+	target := db.syncer.written
+	db.appendMtx.Unlock()
+	db.syncer.done(target, errDestroyed)
+}
+
+// Flushes buffered writes and commits the database file to stable storage
+func (db *SimpleDb[T]) Sync() error {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	if err := db.syncAll(); err != nil {
+		return &DbInternalError{oper: "syncing", err: err}
+	}
+	return nil
+}
+
+// syncs the writes made in each interval with a single fsync, until the db is closed
+func (db *SimpleDb[T]) syncPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closed:
+			return
+		case <-ticker.C:
+		}
+
+		db.mtx.RLock()
+		select {
+		case <-db.closed: // closed while waiting for the lock, Close syncs everything
+			db.mtx.RUnlock()
+			return
+		default:
+		}
+		file := db.file // the file may get swapped by compaction
+		db.appendMtx.Lock()
+		target := db.syncer.written
+		err := db.writer.flush()
+		db.appendMtx.Unlock()
+		db.mtx.RUnlock()
+
+		db.syncer.mtx.Lock()
+		pending := target > db.syncer.synced
+		db.syncer.mtx.Unlock()
+		if !pending {
+			continue
+		}
+		if err == nil { // sync outside the lock, so that writers may carry on meanwhile
+			err = file.Sync()
+		}
+		db.syncer.done(target, err)
+	}
+}
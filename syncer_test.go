@@ -0,0 +1,102 @@
+package simpledb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	const CacheSize = 100
+	const N = 20
+	DeleteDbFile("testGroupCommit")
+
+	db, err := Open[Person]("testGroupCommit", WithCacheSize(CacheSize), WithSyncMode(SyncGroup, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < N; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			if _, err := db.Append(fmt.Sprintf("Person%d", n), &testData[n%len(testData)]); err != nil {
+				t.Error("append failed", err)
+			}
+			// the write must be durable once Append returns
+			db.syncer.mtx.Lock()
+			if db.syncer.synced < 1 {
+				t.Error("append returned before sync")
+			}
+			db.syncer.mtx.Unlock()
+		}(n)
+	}
+	wg.Wait()
+
+	db.syncer.mtx.Lock()
+	if db.syncer.syncs >= N {
+		t.Error("concurrent appends should share syncs, got: ", db.syncer.syncs)
+	}
+	db.syncer.mtx.Unlock()
+	db.Close()
+
+	db, _ = Open[Person]("testGroupCommit", WithCacheSize(CacheSize))
+	if db.ItemsCount != N {
+		t.Error("wrong items count: ", db.ItemsCount)
+	}
+	db.Destroy()
+}
+
+func TestSyncModes(t *testing.T) {
+	const CacheSize = 1
+	DeleteDbFile("testSyncModes")
+
+	db, _ := Open[Person]("testSyncModes", WithCacheSize(CacheSize),
+		WithSyncMode(SyncAlways, 0), WithFlushPolicy(FlushOnClose, 0))
+	db.Append("Person1", &testData[0])
+	if fileSize(db.filePath) != db.currentOffset {
+		t.Error("buffer should be flushed on each write")
+	}
+	db.Close()
+
+	db, _ = Open[Person]("testSyncModes", WithCacheSize(CacheSize), WithFlushPolicy(FlushOnClose, 0))
+	db.Append("Person2", &testData[1])
+	if err := db.Sync(); err != nil {
+		t.Error("sync failed", err)
+	}
+	if fileSize(db.filePath) != db.currentOffset {
+		t.Error("buffer should be flushed on sync")
+	}
+	db.Destroy()
+
+	if _, err := Open[Person]("testSyncModes", WithCacheSize(CacheSize), WithSyncMode(SyncGroup, 0)); err == nil {
+		t.Error("group commit without interval should be rejected")
+	}
+}
+
+func TestDestroyWakesWaiters(t *testing.T) {
+	DeleteDbFile("testDestroyWaiters")
+	db, _ := Open[Person]("testDestroyWaiters", WithSyncMode(SyncGroup, time.Hour))
+	result := make(chan error)
+	go func() {
+		_, err := db.Put("Person1", &testData[0])
+		result <- err
+	}()
+	for written := uint64(0); written == 0; { // the writer waits for the sync now
+		time.Sleep(time.Millisecond)
+		db.appendMtx.Lock()
+		written = db.syncer.written
+		db.appendMtx.Unlock()
+	}
+	db.Destroy()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("the write should fail, as the db has been destroyed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer waiting for a sync should be woken up by Destroy")
+	}
+}
@@ -0,0 +1,173 @@
+package simpledb
+
+// the version of a key read by a transaction: the id of its item, if there's one
+type txRead struct {
+	id    ID
+	found bool
+}
+
+// A transaction, its reads see its own writes, which are applied atomically when it's committed
+// item IDs serve as the versions of the keys: they are generated in order, and each write of a key creates a new item
+// not safe for concurrent use, and must not be used after the function it's been passed to returns
+type Tx[T any] struct {
+	db       *SimpleDb[T]
+	writable bool
+	start    ID                // the next id to be generated when the transaction started, items from it up are newer
+	reads    map[string]txRead // versions of the keys read from the db
+	writes   map[string]*T     // values written, nil for deletes
+	order    []string          // keys written, in the order of their first write
+}
+
+func (db *SimpleDb[T]) newTx(writable bool) *Tx[T] {
+	db.appendMtx.Lock()
+	start := db.maxId
+	db.appendMtx.Unlock()
+	return &Tx[T]{
+		db:       db,
+		writable: writable,
+		start:    start,
+		reads:    make(map[string]txRead),
+		writes:   make(map[string]*T),
+	}
+}
+
+// Runs fn in a read-only transaction
+// fails with ErrConflict if a key read by fn has been changed by a writer since the transaction started,
+// as fn may have seen a part of a batch or transaction then, its results must be discarded, and it may be retried
+func (db *SimpleDb[T]) View(fn func(tx *Tx[T]) error) error {
+	tx := db.newTx(false)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	db.rLockShards()
+	defer db.rUnlockShards()
+	return tx.validate()
+}
+
+// Runs fn in a read-write transaction, and commits its writes atomically if fn returns no error
+// fails with ErrConflict, without applying any writes, if a key read by fn has been changed by another writer since the transaction started
+// it's not called Update, as Update updates the value of a single key
+func (db *SimpleDb[T]) UpdateTx(fn func(tx *Tx[T]) error) error {
+	tx := db.newTx(true)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	ops := make([]batchOp[T], 0, len(tx.writes))
+	for _, key := range tx.order {
+		if value, ok := tx.writes[key]; ok {
+			ops = append(ops, batchOp[T]{key: key, value: value})
+			delete(tx.writes, key) // a key deleted and put again is in the order twice
+		}
+	}
+	return db.commit(ops, tx)
+}
+
+// Gets the value for the given key, as written by the transaction, or from the db
+func (tx *Tx[T]) Get(key string) (*T, error) {
+	if value, ok := tx.writes[key]; ok {
+		if value == nil {
+			return nil, &NotFoundError{}
+		}
+		return value, nil
+	}
+	value, found, err := tx.read(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &NotFoundError{}
+	}
+	return value, nil
+}
+
+// Checks if there's an item with the given key, as written by the transaction, or in the db
+func (tx *Tx[T]) Has(key string) (bool, error) {
+	if value, ok := tx.writes[key]; ok {
+		return value != nil, nil
+	}
+	_, found, err := tx.read(key)
+	return found, err
+}
+
+// Puts a key, value pair, replacing the value if the key exists
+func (tx *Tx[T]) Put(key string, value *T) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	tx.write(key, value)
+	return nil
+}
+
+// Deletes the item with the given key, fails with NotFoundError if there's no such key
+func (tx *Tx[T]) Delete(key string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	value, written := tx.writes[key]
+	if written && value == nil {
+		return &NotFoundError{}
+	}
+	_, found, err := tx.read(key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case found:
+		tx.write(key, nil)
+	case written: // put by the transaction only
+		delete(tx.writes, key)
+	default:
+		return &NotFoundError{}
+	}
+	return nil
+}
+
+func (tx *Tx[T]) write(key string, value *T) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = value
+}
+
+// reads the key from the db, recording the version of the first read
+func (tx *Tx[T]) read(key string) (value *T, found bool, err error) {
+	db := tx.db
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	keyHash := db.keyHash(key)
+	s := db.shardOf(keyHash)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	id, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		if _, value, err = db.getShardItem(s, id); err != nil {
+			return nil, false, err
+		}
+	}
+	if _, ok := tx.reads[key]; !ok {
+		tx.reads[key] = txRead{id: id, found: found}
+	}
+	return value, found, nil
+}
+
+// checks that the keys read have not been changed since the transaction started, their shards must be locked
+func (tx *Tx[T]) validate() error {
+	db := tx.db
+	for key, read := range tx.reads {
+		keyHash := db.keyHash(key)
+		id, found, err := db.findItem(db.shardOf(keyHash), key, keyHash)
+		if err != nil {
+			return err
+		}
+		if found != read.found || id != read.id || (found && id >= tx.start) {
+			return ErrConflict
+		}
+	}
+	return nil
+}
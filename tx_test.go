@@ -0,0 +1,173 @@
+package simpledb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	DeleteDbFile("testTx")
+	db, _ := Open[Person]("testTx")
+	defer db.Destroy()
+	db.Put("Person1", &testData[0])
+	db.Put("Person2", &testData[1])
+
+	err := db.UpdateTx(func(tx *Tx[Person]) error {
+		p, err := tx.Get("Person1")
+		if err != nil {
+			return err
+		}
+		moved := *p
+		tx.Put("Person3", &moved)
+		tx.Delete("Person1")
+		tx.Put("Temp", &testData[2])
+		tx.Delete("Temp") // never persisted
+		if ok, _ := tx.Has("Person1"); ok {
+			t.Error("transaction should see its own delete")
+		}
+		if p, _ := tx.Get("Person3"); p == nil || *p != testData[0] {
+			t.Error("transaction should see its own put")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("failed to commit: ", err)
+	}
+	keys, _ := db.Keys()
+	if len(keys) != 2 {
+		t.Error("wrong keys after commit: ", keys)
+	}
+	if p, err := db.Get("Person3"); err != nil || *p != testData[0] {
+		t.Error("wrong value after commit: ", p, err)
+	}
+
+	failed := errors.New("failed")
+	if err = db.UpdateTx(func(tx *Tx[Person]) error {
+		tx.Put("Person4", &testData[0])
+		return failed
+	}); err != failed {
+		t.Error("error of the function should be returned, got: ", err)
+	}
+	if ok, _ := db.Has("Person4"); ok {
+		t.Error("failed transaction should not be applied")
+	}
+
+	if err = db.View(func(tx *Tx[Person]) error {
+		return tx.Put("Person4", &testData[0])
+	}); !errors.Is(err, ErrReadOnly) {
+		t.Error("view should not write, got: ", err)
+	}
+}
+
+func TestTxConflict(t *testing.T) {
+	DeleteDbFile("testTxConflict")
+	db, _ := Open[Person]("testTxConflict")
+	defer db.Destroy()
+	db.Put("Person1", &testData[0])
+
+	for name, fn := range map[string]func(tx *Tx[Person]) error{
+		"changed after read": func(tx *Tx[Person]) error {
+			tx.Get("Person1")
+			db.Put("Person1", &testData[1]) // another writer
+			return tx.Put("Person2", &testData[0])
+		},
+		"changed before read": func(tx *Tx[Person]) error {
+			db.Put("Person1", &testData[2])
+			tx.Get("Person1")
+			return tx.Put("Person2", &testData[0])
+		},
+		"inserted after read": func(tx *Tx[Person]) error {
+			if ok, _ := tx.Has("Person3"); !ok {
+				db.Put("Person3", &testData[0])
+			}
+			return tx.Put("Person2", &testData[0])
+		},
+	} {
+		if err := db.UpdateTx(fn); !errors.Is(err, ErrConflict) {
+			t.Errorf("%s: expected conflict, got %v", name, err)
+		}
+		if ok, _ := db.Has("Person2"); ok {
+			t.Errorf("%s: conflicting transaction should not be applied", name)
+		}
+	}
+
+	if err := db.UpdateTx(func(tx *Tx[Person]) error { // blind writes do not conflict
+		db.Put("Person1", &testData[0])
+		return tx.Put("Person1", &testData[1])
+	}); err != nil {
+		t.Error("blind write should not conflict: ", err)
+	}
+}
+
+func TestViewConsistency(t *testing.T) {
+	DeleteDbFile("testViewConsistency")
+	db, _ := Open[Person]("testViewConsistency")
+	defer db.Destroy()
+	db.Put("Person1", &testData[0])
+	db.Put("Person2", &testData[0])
+
+	read := func(tx *Tx[Person]) error {
+		_, err := tx.Get("Person1")
+		if err == nil {
+			_, err = tx.Get("Person2")
+		}
+		return err
+	}
+	if err := db.View(read); err != nil {
+		t.Error("view failed: ", err)
+	}
+	// a batch applied between the reads, the view sees a half of it
+	err := db.View(func(tx *Tx[Person]) error {
+		tx.Get("Person1")
+		b := db.NewBatch()
+		b.Put("Person1", &testData[1])
+		b.Put("Person2", &testData[1])
+		b.Commit()
+		tx.Get("Person2")
+		return nil
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Error("view of a partly applied batch should fail, got: ", err)
+	}
+	if err = db.View(read); err != nil {
+		t.Error("view failed: ", err)
+	}
+}
+
+func TestTxCounter(t *testing.T) {
+	const workers, increments = 4, 50
+	DeleteDbFile("testTxCounter")
+	db, _ := Open[Person]("testTxCounter")
+	defer db.Destroy()
+	db.Put("counter", &Person{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				for {
+					err := db.UpdateTx(func(tx *Tx[Person]) error {
+						p, err := tx.Get("counter")
+						if err != nil {
+							return err
+						}
+						return tx.Put("counter", &Person{Age: p.Age + 1})
+					})
+					if !errors.Is(err, ErrConflict) {
+						if err != nil {
+							t.Error(err)
+						}
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if p, _ := db.Get("counter"); p.Age != workers*increments {
+		t.Error("lost updates: ", p.Age)
+	}
+}
@@ -0,0 +1,148 @@
+package simpledb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/kkonat/simpledb/hash"
+)
+
+const upgradeExt = ".upgrade" // the file being written while upgrading a legacy file
+
+// header of the blocks in legacy files, which have no file header, markers nor checksums
+type legacyBlockHeader struct {
+	Length  uint32
+	Id      ID
+	KeyHash hash.Type
+	KeyLen  uint32
+	DataLen uint32
+}
+
+func legacyHeaderSize() int64 {
+	return int64(unsafe.Sizeof(legacyBlockHeader{}))
+}
+
+func (h *legacyBlockHeader) lengthOk() bool {
+	return int64(h.Length) == legacyHeaderSize()+int64(h.KeyLen)+int64(h.DataLen)
+}
+
+// checks if the file has the legacy layout: no magic number, and a chain of legacy blocks ending exactly at its end
+func isLegacyFile(file io.ReaderAt, fileSize int64) (bool, error) {
+	if fileSize == 0 {
+		return false, nil
+	}
+	magic := make([]byte, 4)
+	if _, err := file.ReadAt(magic, 0); err == nil && binary.LittleEndian.Uint32(magic) == fileMagic {
+		return false, nil
+	}
+	headerBytes := make([]byte, legacyHeaderSize())
+	var header legacyBlockHeader
+	for offset := int64(0); offset < fileSize; offset += int64(header.Length) {
+		if _, err := file.ReadAt(headerBytes, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		binary.Read(bytes.NewReader(headerBytes), binary.LittleEndian, &header)
+		if !header.lengthOk() || offset+int64(header.Length) > fileSize {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// converts a legacy file, if the db file is one, to the current layout, once
+// the items are written to a new file, which then replaces the old one, so a crash leaves the legacy file intact
+// legacy values are encoded with borsh, the db must use the same codec
+func (db *SimpleDb[T]) upgradeLegacy() error {
+	src, err := os.Open(db.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	legacy, err := isLegacyFile(src, stat.Size())
+	if err != nil || !legacy {
+		return err
+	}
+	if db.cfg.readOnly {
+		return ErrLegacyFile
+	}
+	if db.cfg.codecId != CodecBorsh {
+		return &FileHeaderError{Field: "codec", Found: uint32(CodecBorsh), Expected: uint32(db.cfg.codecId)}
+	}
+
+	tmpPath := db.filePath + upgradeExt
+	dest, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, db.cfg.perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // does nothing once it's been renamed
+	items, err := db.copyLegacyItems(bufio.NewReader(src), dest)
+	if err == nil {
+		err = dest.Sync()
+	}
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, db.filePath); err != nil {
+		return err
+	}
+	if err = syncDir(filepath.Dir(db.filePath)); err != nil {
+		return err
+	}
+	db.cfg.log.Infof("simpledb: %s: upgraded legacy database file, %d items", db.filePath, items)
+	return nil
+}
+
+// writes the file header and the legacy items as blocks of the current layout, returns the number of items
+func (db *SimpleDb[T]) copyLegacyItems(src io.Reader, dest io.Writer) (items int, err error) {
+	w := bufio.NewWriter(dest)
+	if _, err = w.Write(newFileHeader(&db.cfg).getBytes()); err != nil {
+		return 0, err
+	}
+	var header legacyBlockHeader
+	for {
+		if err = binary.Read(src, binary.LittleEndian, &header); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return items, err
+		}
+		data := make([]byte, header.KeyLen+header.DataLen)
+		if _, err = io.ReadFull(src, data); err != nil {
+			return items, err
+		}
+		key := string(data[:header.KeyLen])
+		block := newBlock(header.Id, key, db.keyHash(key), data[header.KeyLen:])
+		if err = db.compressBlock(block); err != nil {
+			return items, err
+		}
+		if db.sealer != nil {
+			if err = db.sealer.seal(block); err != nil {
+				return items, err
+			}
+		}
+		if _, err = w.Write(block.getBytes()); err != nil {
+			return items, err
+		}
+		items++
+	}
+	return items, w.Flush()
+}
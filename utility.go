@@ -2,26 +2,41 @@ package simpledb
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 )
 
-// processes relative dirs and returns final filepath
-func getFilepath(filename string) string {
+// processes relative dirs and returns final filepath, with the DbPath subdir next to the file
+func getFilepath(filename string, ext string) string {
 	dbDataFile := filepath.Clean(filename)
 	dir, file := filepath.Split(dbDataFile)
-	dataFilePath := filepath.Join(dir, DbPath, file+DbExt)
+	dataFilePath := filepath.Join(dir, DbPath, file+ext)
 	return dataFilePath
 }
 
 // opens the file, used for keeping track of the open/rw/create mode
-func openFile(path string) (file *os.File, err error) {
-	file, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
+// a read-only file must exist, it's never written to, so it may be on a read-only filesystem
+func openFile(path string, perm os.FileMode, readOnly bool) (file *os.File, err error) {
+	if readOnly {
+		return os.OpenFile(path, os.O_RDONLY, 0)
+	}
+	file, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, perm)
 	return
 }
 
+// commits the directory to stable storage, so that renames and file creations in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 // prints out the []byte slice content
 func printBytes(bytes []byte) {
 	for _, b := range bytes {
@@ -83,3 +98,15 @@ func genRandomSequence(N int) []int {
 	}
 	return seq
 }
+
+// implements io.ReaderAt semantics for a byte slice
+func readAtBuffer(buff []byte, p []byte, off int64) (n int, err error) {
+	if off >= int64(len(buff)) {
+		return 0, io.EOF
+	}
+	n = copy(p, buff[off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,114 @@
+package simpledb
+
+// Version of a key's value, it changes with each write of the key and stays the same across compactions and reopening
+// it's derived from the ID of the key's item, which is generated in order, 0 means there's no such key
+type Version uint64
+
+// the version of a missing key
+const NoVersion Version = 0
+
+func versionOf(id ID) Version {
+	return Version(id) + 1
+}
+
+// Gets the value for the given key together with its version, for CompareAndSwap
+func (db *SimpleDb[T]) GetWithVersion(key string) (*T, Version, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	keyHash := db.keyHash(key)
+	s := db.shardOf(keyHash)
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	id, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	if !found {
+		return nil, NoVersion, &NotFoundError{}
+	}
+	_, value, err := db.getShardItem(s, id)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return value, versionOf(id), nil
+}
+
+// Puts the value, if the key's current version is the expected one, NoVersion if the key should not exist,
+// otherwise fails with ErrConflict, returns the new version
+func (db *SimpleDb[T]) CompareAndSwap(key string, expected Version, value *T) (version Version, err error) {
+	if db.cfg.readOnly {
+		return NoVersion, ErrReadOnly
+	}
+	keyHash := db.keyHash(key)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
+
+	oldId, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return NoVersion, err
+	}
+	current := NoVersion
+	if found {
+		current = versionOf(oldId)
+	}
+	if current != expected {
+		return NoVersion, ErrConflict
+	}
+	var id ID
+	if found {
+		id, err = db.replaceItem(s, oldId, key, keyHash, value)
+	} else {
+		id, err = db.appendItem(s, key, value)
+	}
+	if err != nil {
+		return NoVersion, err
+	}
+	return versionOf(id), nil
+}
+
+// Updates the value of the key with the one returned by fn, which gets a copy of the current value, or nil if there's no such key
+// if fn returns nil, the key is deleted, if it returns an error, nothing is changed and the error is returned
+// the key's shard stays locked while fn runs, so it must not call the db, returns the new version
+func (db *SimpleDb[T]) UpdateFunc(key string, fn func(old *T) (*T, error)) (version Version, err error) {
+	if db.cfg.readOnly {
+		return NoVersion, ErrReadOnly
+	}
+	keyHash := db.keyHash(key)
+	s := db.lockShard(keyHash)
+	defer db.unlockDurable(&err, s)
+
+	oldId, found, err := db.findItem(s, key, keyHash)
+	if err != nil {
+		return NoVersion, err
+	}
+	var old *T
+	if found {
+		_, value, err := db.getShardItem(s, oldId)
+		if err != nil {
+			return NoVersion, err
+		}
+		copied := *value // the cached value must not change, if fn fails
+		old = &copied
+	}
+	value, err := fn(old)
+	if err != nil {
+		return NoVersion, err
+	}
+
+	var id ID
+	switch {
+	case value == nil && found:
+		return NoVersion, db.removeItem(s, oldId, key, keyHash)
+	case value == nil:
+		return NoVersion, nil
+	case found:
+		id, err = db.replaceItem(s, oldId, key, keyHash, value)
+	default:
+		id, err = db.appendItem(s, key, value)
+	}
+	if err != nil {
+		return NoVersion, err
+	}
+	return versionOf(id), nil
+}
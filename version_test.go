@@ -0,0 +1,108 @@
+package simpledb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	DeleteDbFile("testCAS")
+	db, _ := Open[Person]("testCAS")
+
+	v1, err := db.CompareAndSwap("lease", NoVersion, &testData[0])
+	if err != nil || v1 == NoVersion {
+		t.Fatal("failed to create the key: ", err)
+	}
+	if _, err = db.CompareAndSwap("lease", NoVersion, &testData[1]); !errors.Is(err, ErrConflict) {
+		t.Error("existing key should not be created again, got: ", err)
+	}
+	v2, err := db.CompareAndSwap("lease", v1, &testData[1])
+	if err != nil || v2 == v1 {
+		t.Error("failed to swap: ", err)
+	}
+	if _, err = db.CompareAndSwap("lease", v1, &testData[2]); !errors.Is(err, ErrConflict) {
+		t.Error("stale version should be rejected, got: ", err)
+	}
+	db.Compact(context.Background())
+	db.Close()
+
+	db, _ = Open[Person]("testCAS") // versions persist
+	defer db.Destroy()
+	if val, v, err := db.GetWithVersion("lease"); err != nil || v != v2 || *val != testData[1] {
+		t.Error("wrong value or version: ", val, v, err)
+	}
+	var notFound *NotFoundError
+	if _, v, err := db.GetWithVersion("missing"); v != NoVersion || !errors.As(err, &notFound) {
+		t.Error("missing key should have no version: ", v, err)
+	}
+}
+
+func TestVersionsAfterCompaction(t *testing.T) {
+	DeleteDbFile("testVersionsCompaction")
+	db, _ := Open[Person]("testVersionsCompaction")
+	db.Put("a", &testData[0])
+	db.Put("b", &testData[1])
+	_, stale, _ := db.GetWithVersion("b")
+	db.Delete("b")
+	if err := db.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	crash(db) // no hint, the ids are recovered from the compacted file
+
+	db, _ = Open[Person]("testVersionsCompaction")
+	defer db.Destroy()
+	v, err := db.CompareAndSwap("b", NoVersion, &testData[2])
+	if err != nil || v <= stale {
+		t.Error("a key created again must get a new version: ", v, stale, err)
+	}
+	if _, err = db.CompareAndSwap("b", stale, &testData[0]); !errors.Is(err, ErrConflict) {
+		t.Error("version of the deleted item should be rejected, got: ", err)
+	}
+}
+
+func TestUpdateFunc(t *testing.T) {
+	const workers, increments = 4, 50
+	DeleteDbFile("testUpdateFunc")
+	db, _ := Open[Person]("testUpdateFunc")
+	defer db.Destroy()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				db.UpdateFunc("counter", func(old *Person) (*Person, error) {
+					if old == nil {
+						return &Person{Age: 1}, nil
+					}
+					old.Age++
+					return old, nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if p, _ := db.Get("counter"); p.Age != workers*increments {
+		t.Error("lost updates: ", p.Age)
+	}
+
+	failed := errors.New("failed")
+	if _, err := db.UpdateFunc("counter", func(old *Person) (*Person, error) {
+		old.Age = 0
+		return nil, failed
+	}); err != failed {
+		t.Error("error of the function should be returned, got: ", err)
+	}
+	if p, _ := db.Get("counter"); p.Age != workers*increments {
+		t.Error("failed update should not change the value: ", p.Age)
+	}
+	if v, err := db.UpdateFunc("counter", func(old *Person) (*Person, error) { return nil, nil }); err != nil || v != NoVersion {
+		t.Error("failed to delete: ", err)
+	}
+	if ok, _ := db.Has("counter"); ok {
+		t.Error("key should be deleted")
+	}
+}
@@ -0,0 +1,137 @@
+package simpledb
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// determines when buffered writes are flushed to the database file
+type FlushPolicy int
+
+const (
+	FlushNone    FlushPolicy = iota // writes are not buffered, each block is written to the file right away
+	FlushOnClose                    // blocks are buffered and flushed when the buffer is full, on Flush(), on Close and periodically if the interval is set
+	FlushEveryN                     // as FlushOnClose, and additionally the buffer is flushed after every N blocks
+)
+
+// appends blocks to the database file, grouping them in a write buffer according to the flush policy
+type blockWriter struct {
+	mtx     sync.RWMutex // the buffer is read by Get while blocks are appended
+	file    *os.File
+	policy  FlushPolicy
+	every   int
+	buff    []byte
+	blocks  int   // number of blocks in the buffer
+	flushed int64 // length of the file, i.e. offset of the first buffered byte
+}
+
+func newBlockWriter(file *os.File, length int64, cfg *config) *blockWriter {
+	w := &blockWriter{
+		file:    file,
+		policy:  cfg.flushPolicy,
+		every:   cfg.flushEvery,
+		flushed: length,
+	}
+	if w.policy != FlushNone {
+		w.buff = make([]byte, 0, bulkWriteSize)
+	}
+	return w
+}
+
+// writes one or more blocks, as a whole, either to the file or to the buffer, returns the number of bytes accepted
+// buffered blocks are accepted even if flushing the buffer fails then, they stay buffered and the next flush retries them
+func (w *blockWriter) write(blocks []byte) (n int, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.policy == FlushNone {
+		if n, err = w.file.Write(blocks); err != nil {
+			if n > 0 { // drop the torn part, so the next blocks are written where they are expected
+				w.file.Truncate(w.flushed)
+			}
+			return 0, err
+		}
+		w.flushed += int64(n)
+		return n, nil
+	}
+	w.buff = append(w.buff, blocks...)
+	w.blocks++
+	if int64(len(w.buff)) >= bulkWriteSize || (w.policy == FlushEveryN && w.blocks >= w.every) {
+		return len(blocks), w.flushBuffer()
+	}
+	return len(blocks), nil
+}
+
+// writes the buffered blocks to the file
+func (w *blockWriter) flush() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.flushBuffer()
+}
+
+func (w *blockWriter) flushBuffer() error {
+	if len(w.buff) == 0 {
+		return nil
+	}
+	n, err := w.file.Write(w.buff)
+	w.flushed += int64(n)
+	if err != nil {
+		w.buff = w.buff[:copy(w.buff, w.buff[n:])] // keep what has not been written
+		return err
+	}
+	w.buff = w.buff[:0]
+	w.blocks = 0
+	return nil
+}
+
+// reads from the file, or from the buffer if the data has not been flushed yet
+// a block spans the two only if a flush has been interrupted, the rest of it is still in the buffer then
+func (w *blockWriter) ReadAt(p []byte, off int64) (int, error) {
+	w.mtx.RLock()
+	if off+int64(len(p)) <= w.flushed { // flushed data does not change, read it without holding the lock
+		file := w.file
+		w.mtx.RUnlock()
+		return file.ReadAt(p, off)
+	}
+	defer w.mtx.RUnlock()
+	if off < w.flushed {
+		n, err := w.file.ReadAt(p[:w.flushed-off], off)
+		if err != nil {
+			return n, err
+		}
+		m, err := readAtBuffer(w.buff, p[n:], 0)
+		return n + m, err
+	}
+	return readAtBuffer(w.buff, p, off-w.flushed)
+}
+
+// Flushes buffered writes to the database file
+func (db *SimpleDb[T]) Flush() error {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	if err := db.writer.flush(); err != nil {
+		return &DbInternalError{oper: "flushing", err: err}
+	}
+	return nil
+}
+
+// flushes the write buffer periodically, until the db is closed
+func (db *SimpleDb[T]) flushPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closed:
+			return
+		case <-ticker.C:
+			db.mtx.RLock()
+			select {
+			case <-db.closed: // closed while waiting for the lock
+			default:
+				db.writer.flush()
+			}
+			db.mtx.RUnlock()
+		}
+	}
+}
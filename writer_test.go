@@ -0,0 +1,111 @@
+package simpledb
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func fileSize(path string) int64 {
+	stat, _ := os.Stat(path)
+	return stat.Size()
+}
+
+func TestWriteBuffer(t *testing.T) {
+	const CacheSize = 1
+	DeleteDbFile("testWriteBuffer")
+
+	db, err := Open[Person]("testWriteBuffer", WithCacheSize(CacheSize), WithFlushPolicy(FlushOnClose, 0))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Append("Person1", &testData[0])
+	db.Append("Person2", &testData[1]) // evicts Person1 from the cache
+	if fileSize(db.filePath) != fileHeaderSize() {
+		t.Error("blocks should be buffered")
+	}
+	// buffered, but not yet flushed items are served from the buffer
+	if val, err := db.Get("Person1"); err != nil || *val != testData[0] {
+		t.Error("failed to get a buffered item", err)
+	}
+	if err = db.Flush(); err != nil {
+		t.Error("flush failed", err)
+	}
+	if fileSize(db.filePath) != db.currentOffset {
+		t.Error("blocks should be flushed")
+	}
+	db.Append("Person3", &testData[2])
+	db.Close()
+
+	db, _ = Open[Person]("testWriteBuffer", WithCacheSize(CacheSize))
+	if val, err := db.Get("Person3"); err != nil || *val != testData[2] {
+		t.Error("buffered item should be flushed on close")
+	}
+	db.Destroy()
+}
+
+func TestFlushPolicies(t *testing.T) {
+	const CacheSize = 1
+	DeleteDbFile("testFlushPolicies")
+
+	db, _ := Open[Person]("testFlushPolicies", WithCacheSize(CacheSize), WithFlushPolicy(FlushEveryN, 2))
+	db.Append("Person1", &testData[0])
+	if fileSize(db.filePath) != fileHeaderSize() {
+		t.Error("first block should be buffered")
+	}
+	db.Append("Person2", &testData[1])
+	if fileSize(db.filePath) != db.currentOffset {
+		t.Error("buffer should be flushed after 2 blocks")
+	}
+	db.Close()
+
+	db, _ = Open[Person]("testFlushPolicies", WithCacheSize(CacheSize),
+		WithFlushPolicy(FlushOnClose, 0), WithFlushInterval(10*time.Millisecond))
+	db.Append("Person3", &testData[2])
+	time.Sleep(100 * time.Millisecond)
+	db.mtx.RLock()
+	if fileSize(db.filePath) != db.currentOffset {
+		t.Error("buffer should be flushed periodically")
+	}
+	db.mtx.RUnlock()
+	db.Destroy()
+
+	if _, err := Open[Person]("testFlushPolicies", WithCacheSize(CacheSize), WithFlushPolicy(FlushEveryN, 0)); err == nil {
+		t.Error("invalid flush policy should be rejected")
+	}
+}
+
+func TestFailedFlush(t *testing.T) {
+	DeleteDbFile("testFailedFlush")
+	db, _ := Open[Person]("testFailedFlush", WithFlushPolicy(FlushEveryN, 1), WithCacheSize(0))
+	db.Put("a", &testData[0])
+
+	// writes to the file fail, e.g. the disk is full
+	file := db.writer.file
+	readOnly, _ := os.Open(db.filePath)
+	defer readOnly.Close()
+	db.writer.file = readOnly
+	if _, err := db.Put("b", &testData[1]); err != nil {
+		t.Error("a buffered block is accepted, even if it can't be flushed yet: ", err)
+	}
+	if err := db.Flush(); err == nil {
+		t.Error("flush should fail")
+	}
+	db.writer.file = file
+
+	db.Put("c", &testData[2])
+	for i, key := range []string{"a", "b", "c"} {
+		if val, err := db.Get(key); err != nil || *val != testData[i] {
+			t.Error("failed to get ", key, val, err)
+		}
+	}
+	db.Close()
+
+	db, _ = Open[Person]("testFailedFlush")
+	defer db.Destroy()
+	for i, key := range []string{"a", "b", "c"} {
+		if val, err := db.Get(key); err != nil || *val != testData[i] {
+			t.Error("failed to get after reopening ", key, val, err)
+		}
+	}
+}